@@ -9,6 +9,8 @@ import (
 	"github.com/anthropics/anthropic-sdk-go/option"
 	gitignore "github.com/sabhiram/go-gitignore"
 	"log/slog"
+	"net/http"
+	"slices"
 	"strings"
 	"time"
 )
@@ -18,38 +20,54 @@ type anthropicExecutor struct {
 	logger  *slog.Logger
 	ignorer *gitignore.GitIgnore
 	config  GenConfig
+	// tokenizer counts the tokens of the input before it is sent
+	tokenizer Tokenizer
+	// messages holds the conversation so far, so that subsequent calls to Execute continue it
+	messages []a.BetaMessageParam
+	// response is the text of the model's final response to the last input
+	response string
 }
 
-func NewAnthropicExecutor(baseUrl string, apiKey string, logger *slog.Logger, ignorer *gitignore.GitIgnore, config GenConfig) Executor {
+func NewAnthropicExecutor(baseUrl string, apiKey string, httpClient *http.Client, logger *slog.Logger, ignorer *gitignore.GitIgnore, config GenConfig) (Executor, error) {
+	if err := validateGenConfig(anthropicLimits, config); err != nil {
+		return nil, fmt.Errorf("invalid generation config: %w", err)
+	}
+
 	opts := []option.RequestOption{
 		option.WithAPIKey(apiKey),
 		option.WithMaxRetries(5),
 		option.WithRequestTimeout(5 * time.Minute),
 	}
+	forEachHeader(config.Headers, func(name, value string) {
+		opts = append(opts, option.WithHeaderAdd(name, value))
+	})
+	if httpClient != nil {
+		opts = append(opts, option.WithHTTPClient(httpClient))
+	}
 	if baseUrl != "" {
-		// Ensure baseURL ends with a trailing "/"
-		if !strings.HasSuffix(baseUrl, "/") {
-			baseUrl = baseUrl + "/"
-		}
-		opts = append(opts, option.WithBaseURL(baseUrl))
+		opts = append(opts, option.WithBaseURL(normalizeBaseURL(baseUrl)))
 	}
 	client := a.NewClient(opts...)
 	return &anthropicExecutor{
-		client:  client,
-		logger:  logger,
-		ignorer: ignorer,
-		config:  config,
-	}
+		client:    client,
+		logger:    logger,
+		ignorer:   ignorer,
+		config:    config,
+		tokenizer: selectTokenizer(config.Model, client, logger),
+	}, nil
 }
 
 func (s *anthropicExecutor) Execute(input string) error {
+	if err := checkInputSize(s.tokenizer, s.config, systemPromptFor(s.config), s.messages, input); err != nil {
+		return err
+	}
 	params := a.BetaMessageNewParams{
 		Model:       a.F(s.config.Model),
 		MaxTokens:   a.F(int64(s.config.MaxTokens)),
 		Temperature: a.F(float64(s.config.Temperature)),
 		System: a.F([]a.BetaTextBlockParam{
 			{
-				Text: a.String(agentInstructions),
+				Text: a.String(systemPromptFor(s.config)),
 				Type: a.F(a.BetaTextBlockParamTypeText),
 			},
 		}),
@@ -74,7 +92,8 @@ func (s *anthropicExecutor) Execute(input string) error {
 				Name:        a.String(filesOverviewTool.Name),
 				Description: a.String(filesOverviewTool.Description),
 				InputSchema: a.F(a.BetaToolInputSchemaParam{
-					Type: a.F(a.BetaToolInputSchemaTypeObject),
+					Type:       a.F(a.BetaToolInputSchemaTypeObject),
+					Properties: a.F[any](filesOverviewTool.InputSchema["properties"]),
 				}),
 			},
 			&a.BetaToolParam{
@@ -85,9 +104,55 @@ func (s *anthropicExecutor) Execute(input string) error {
 					Properties: a.F[any](getRelatedFilesTool.InputSchema["properties"]),
 				}),
 			},
+			&a.BetaToolParam{
+				Name:        a.String(applyPatchTool.Name),
+				Description: a.String(applyPatchTool.Description),
+				InputSchema: a.F(a.BetaToolInputSchemaParam{
+					Type:       a.F(a.BetaToolInputSchemaTypeObject),
+					Properties: a.F[any](applyPatchTool.InputSchema["properties"]),
+				}),
+			},
+			&a.BetaToolParam{
+				Name:        a.String(fetchURLTool.Name),
+				Description: a.String(fetchURLTool.Description),
+				InputSchema: a.F(a.BetaToolInputSchemaParam{
+					Type:       a.F(a.BetaToolInputSchemaTypeObject),
+					Properties: a.F[any](fetchURLTool.InputSchema["properties"]),
+				}),
+			},
+			&a.BetaToolParam{
+				Name:        a.String(runTestsTool.Name),
+				Description: a.String(runTestsTool.Description),
+				InputSchema: a.F(a.BetaToolInputSchemaParam{
+					Type: a.F(a.BetaToolInputSchemaTypeObject),
+				}),
+			},
+			&a.BetaToolParam{
+				Name:        a.String(readSymbolTool.Name),
+				Description: a.String(readSymbolTool.Description),
+				InputSchema: a.F(a.BetaToolInputSchemaParam{
+					Type:       a.F(a.BetaToolInputSchemaTypeObject),
+					Properties: a.F[any](readSymbolTool.InputSchema["properties"]),
+				}),
+			},
+			&a.BetaToolParam{
+				Name:        a.String(gitDiffTool.Name),
+				Description: a.String(gitDiffTool.Description),
+				InputSchema: a.F(a.BetaToolInputSchemaParam{
+					Type:       a.F(a.BetaToolInputSchemaTypeObject),
+					Properties: a.F[any](gitDiffTool.InputSchema["properties"]),
+				}),
+			},
 		}),
 	}
 
+	params.Tools = a.F(slices.DeleteFunc(params.Tools.Value, func(tool a.BetaToolUnionUnionParam) bool {
+		return !toolEnabled(s.config, tool.(*a.BetaToolParam).Name.Value)
+	}))
+	for _, tool := range params.Tools.Value {
+		tool := tool.(*a.BetaToolParam)
+		tool.Description = a.String(toolDescription(s.config, tool.Name.Value, tool.Description.Value))
+	}
 	if s.config.TopP != nil {
 		params.TopP = a.F(float64(*s.config.TopP))
 	}
@@ -98,141 +163,200 @@ func (s *anthropicExecutor) Execute(input string) error {
 		params.StopSequences = a.F(s.config.Stop)
 	}
 
-	params.Messages = a.F([]a.BetaMessageParam{
-		{
+	params.Messages = a.F(append(s.messages, a.BetaMessageParam{
+		Content: a.F([]a.BetaContentBlockParamUnion{
+			a.BetaTextBlockParam{
+				Text: a.F(input),
+				Type: a.F(a.BetaTextBlockParamTypeText),
+				CacheControl: a.F(a.BetaCacheControlEphemeralParam{
+					Type: a.F(a.BetaCacheControlEphemeralTypeEphemeral),
+				}),
+			},
+		}),
+		Role: a.F(a.BetaMessageParamRoleUser),
+	}))
+
+	// Keep the conversation so the next call to Execute continues it. The cache breakpoint is dropped
+	// from this turn's input, since the API limits how many blocks in a request may set cache control
+	inputIdx := len(s.messages)
+	defer func() {
+		messages := params.Messages.Value
+		messages[inputIdx] = a.BetaMessageParam{
 			Content: a.F([]a.BetaContentBlockParamUnion{
 				a.BetaTextBlockParam{
 					Text: a.F(input),
 					Type: a.F(a.BetaTextBlockParamTypeText),
-					CacheControl: a.F(a.BetaCacheControlEphemeralParam{
-						Type: a.F(a.BetaCacheControlEphemeralTypeEphemeral),
-					}),
 				},
 			}),
 			Role: a.F(a.BetaMessageParamRoleUser),
-		},
-	})
+		}
+		s.messages = messages
+	}()
+
+	s.response = ""
+	tracker := newToolCallTracker(s.config.MaxToolRepeats)
+	cache := newToolCache()
+	concludeRetries := 0
+	for iterations := 0; ; iterations++ {
+		if err := checkMaxIterations(s.config, iterations); err != nil {
+			return err
+		}
 
-	for {
-		// Create message
+		// Create message. The kept conversation can hold adjacent messages of the same role, e.g. when a previous
+		// input stopped after tool results, so a normalized copy of it is sent
+		request := params
+		request.Messages = a.F(normalizeAnthropicMessages(params.Messages.Value))
+		turnStart := time.Now()
 		resp, respErr := s.client.Beta.Messages.New(context.Background(),
-			params,
+			request,
 		)
 		if respErr != nil {
 			return fmt.Errorf("failed to create message stream: %w", respErr)
 		}
+		logTurnDuration(s.logger, turnStart)
 
 		finished := true
 		assistantMsgContentBlocks := make([]a.BetaContentBlockParamUnion, len(resp.Content))
-		var toolUseId string
+		var calls []toolInvocation
+		var text []string
 		for i, block := range resp.Content {
 			switch block.Type {
 			case a.BetaContentBlockTypeText:
 				s.logger.Info(block.Text)
+				text = append(text, block.Text)
 				assistantMsgContentBlocks[i] = &a.BetaTextBlockParam{
 					Text: a.F(block.Text),
 					Type: a.F(a.BetaTextBlockParamTypeText),
 				}
 			case a.BetaContentBlockTypeToolUse:
 				finished = false
-				toolUseId = block.ID
 				assistantMsgContentBlocks[i] = &a.BetaToolUseBlockParam{
-					ID:    a.F(toolUseId),
+					ID:    a.F(block.ID),
 					Input: a.F(block.Input),
 					Name:  a.F(block.Name),
 					Type:  a.F(a.BetaToolUseBlockParamTypeToolUse),
 				}
-				var result *ToolResult
-				var err error
-				switch block.Name {
-				case bashTool.Name:
-					bashToolInput := struct {
-						Command string `json:"command"`
-					}{}
-					jsonInput, marshalErr := json.Marshal(block.Input)
-					if marshalErr != nil {
-						return fmt.Errorf("failed to marshal bash tool input: %w", marshalErr)
-					}
-					if err := json.Unmarshal(jsonInput, &bashToolInput); err != nil {
-						return fmt.Errorf("failed to unmarshal bash tool arguments: %w", err)
-					}
-					s.logger.Info(fmt.Sprintf("executing bash command: %s", bashToolInput.Command))
-					result, err = executeBashTool(bashToolInput.Command)
-				case fileEditor.Name:
-					var fileEditorToolInput FileEditorParams
-					jsonInput, marshalErr := json.Marshal(block.Input)
-					if marshalErr != nil {
-						return fmt.Errorf("failed to marshal file editor tool input: %w", marshalErr)
-					}
-					if err := json.Unmarshal(jsonInput, &fileEditorToolInput); err != nil {
-						return fmt.Errorf("failed to unmarshal file editor tool arguments: %w", err)
-					}
-					s.logger.Info("executing file editor tool",
-						slog.String("command", fileEditorToolInput.Command),
-						slog.String("path", fileEditorToolInput.Path),
-					)
-
-					s.logger.Info(fmt.Sprintf("old_str:\n%s\n\nnew_str:\n%s", fileEditorToolInput.OldStr, fileEditorToolInput.NewStr))
-					result, err = executeFileEditorTool(fileEditorToolInput)
-				case filesOverviewTool.Name:
-					s.logger.Info("executing files overview tool")
-					result, err = executeFilesOverviewTool(s.ignorer)
-				case getRelatedFilesTool.Name:
-					relatedFilesToolInput := struct {
-						InputFiles []string `json:"input_files"`
-					}{}
-					jsonInput, marshalErr := json.Marshal(block.Input)
-					if marshalErr != nil {
-						return fmt.Errorf("failed to marshal get related files tool input: %w", marshalErr)
-					}
-					if err := json.Unmarshal(jsonInput, &relatedFilesToolInput); err != nil {
-						return fmt.Errorf("failed to unmarshal get related files tool arguments: %w", err)
-					}
-					s.logger.Info("getting related files", slog.Any("input_files", relatedFilesToolInput.InputFiles))
-					result, err = executeGetRelatedFilesTool(relatedFilesToolInput.InputFiles, s.ignorer)
-				default:
-					return fmt.Errorf("unexpected tool use block type: %s", block.Name)
+				rawInput, marshalErr := json.Marshal(block.Input)
+				if marshalErr != nil {
+					return fmt.Errorf("failed to marshal %s tool input: %w", block.Name, marshalErr)
 				}
-
-				if err != nil {
-					return fmt.Errorf("failed to execute tool %s: %w", block.Name, err)
-				}
-
-				resultStr := fmt.Sprintf("tool result: %+v", result.Content)
-				if len(resultStr) > 10000 {
-					resultStr = resultStr[:10000] + "..."
+				repeats := tracker.track(block.Name, rawInput)
+				if tracker.shouldStop(repeats) {
+					s.logger.Warn(fmt.Sprintf("stopping agent: the model repeated the identical %s tool call %d times in a row", block.Name, repeats))
+					return nil
 				}
-				s.logger.Info(resultStr)
-
-				result.ToolUseID = block.ID
+				calls = append(calls, toolInvocation{
+					id:      block.ID,
+					name:    block.Name,
+					input:   rawInput,
+					repeats: repeats,
+				})
+			default:
+				return fmt.Errorf("unexpected content block type: %s", block.Type)
+			}
+		}
+		if finished {
+			s.response = strings.Join(text, "\n")
+			if len(assistantMsgContentBlocks) > 0 {
 				params.Messages = a.F(append(params.Messages.Value, a.BetaMessageParam{
 					Role:    a.F(a.BetaMessageParamRoleAssistant),
 					Content: a.F(assistantMsgContentBlocks),
 				}))
+			}
+			if needsConclusion(s.logger, s.response, concludeRetries) {
+				concludeRetries++
 				params.Messages = a.F(append(params.Messages.Value, a.BetaMessageParam{
+					Role: a.F(a.BetaMessageParamRoleUser),
 					Content: a.F([]a.BetaContentBlockParamUnion{
-						a.BetaToolResultBlockParam{
-							ToolUseID: a.F(toolUseId),
-							Type:      a.F(a.BetaToolResultBlockParamTypeToolResult),
-							Content: a.F([]a.BetaToolResultBlockParamContentUnion{
-								a.BetaToolResultBlockParamContent{
-									Type: a.F(a.BetaToolResultBlockParamContentTypeText),
-									Text: a.F[string](fmt.Sprintf("%+v", result.Content)),
-								},
-							}),
-							IsError: a.F(result.IsError),
+						a.BetaTextBlockParam{
+							Text: a.F(concludePrompt),
+							Type: a.F(a.BetaTextBlockParamTypeText),
 						},
 					}),
-					Role: a.F(a.BetaMessageParamRoleUser),
 				}))
-			default:
-				return fmt.Errorf("unexpected content block type: %s", block.Type)
+				continue
 			}
-		}
-		if finished {
 			break
 		}
+
+		results, err := runToolCalls(calls, s.config.MaxParallelTools, func(call toolInvocation) (*ToolResult, error) {
+			return cache.run(call, func() (*ToolResult, error) {
+				return executeTool(s.logger, s.ignorer, s.config, call.name, call.input)
+			})
+		})
+		if err != nil {
+			return err
+		}
+
+		toolResultBlocks := make([]a.BetaContentBlockParamUnion, len(calls))
+		for i, call := range calls {
+			result := results[i]
+			resultStr := fmt.Sprintf("tool result: %+v", result.Content)
+			if len(resultStr) > 10000 {
+				resultStr = resultStr[:10000] + "..."
+			}
+			s.logger.Debug(resultStr)
+			if tracker.shouldWarn(call.repeats) {
+				result.Content = fmt.Sprintf("%v\n\n%s", result.Content, tracker.repetitionWarning(call.name))
+			}
+
+			result.ToolUseID = call.id
+			toolResultBlocks[i] = a.BetaToolResultBlockParam{
+				ToolUseID: a.F(call.id),
+				Type:      a.F(a.BetaToolResultBlockParamTypeToolResult),
+				Content: a.F([]a.BetaToolResultBlockParamContentUnion{
+					a.BetaToolResultBlockParamContent{
+						Type: a.F(a.BetaToolResultBlockParamContentTypeText),
+						Text: a.F[string](fmt.Sprintf("%+v", result.Content)),
+					},
+				}),
+				IsError: a.F(result.IsError),
+			}
+		}
+		params.Messages = a.F(append(params.Messages.Value,
+			a.BetaMessageParam{
+				Role:    a.F(a.BetaMessageParamRoleAssistant),
+				Content: a.F(assistantMsgContentBlocks),
+			},
+			a.BetaMessageParam{
+				Role:    a.F(a.BetaMessageParamRoleUser),
+				Content: a.F(toolResultBlocks),
+			},
+		))
 	}
 
 	return nil
 }
+
+// placeholderUserMessage starts a conversation that would otherwise start with an assistant message
+const placeholderUserMessage = "Continue."
+
+// normalizeAnthropicMessages returns messages in the strict user/assistant alternation the API requires: messages
+// without content are dropped, adjacent messages of the same role are merged into one, and a placeholder user
+// message is inserted if the conversation would start with the assistant. The given messages are not modified
+func normalizeAnthropicMessages(messages []a.BetaMessageParam) []a.BetaMessageParam {
+	normalized := make([]a.BetaMessageParam, 0, len(messages))
+	for _, msg := range messages {
+		if len(msg.Content.Value) == 0 {
+			continue
+		}
+		if last := len(normalized) - 1; last >= 0 && normalized[last].Role.Value == msg.Role.Value {
+			content := slices.Concat(normalized[last].Content.Value, msg.Content.Value)
+			normalized[last].Content = a.F(content)
+			continue
+		}
+		if len(normalized) == 0 && msg.Role.Value == a.BetaMessageParamRoleAssistant {
+			normalized = append(normalized, a.BetaMessageParam{
+				Role: a.F(a.BetaMessageParamRoleUser),
+				Content: a.F([]a.BetaContentBlockParamUnion{
+					a.BetaTextBlockParam{
+						Text: a.F(placeholderUserMessage),
+						Type: a.F(a.BetaTextBlockParamTypeText),
+					},
+				}),
+			})
+		}
+		normalized = append(normalized, msg)
+	}
+	return normalized
+}
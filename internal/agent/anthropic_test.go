@@ -0,0 +1,338 @@
+package agent
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	a "github.com/anthropics/anthropic-sdk-go"
+	gitignore "github.com/sabhiram/go-gitignore"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newStubAnthropicServer returns a server that answers the n-th messages request with the body returned by respond
+func newStubAnthropicServer(t *testing.T, respond func(n int, reqBody []byte) string) (*httptest.Server, *atomic.Int32) {
+	t.Helper()
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := requests.Add(1)
+		reqBody, err := io.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		io.WriteString(w, respond(int(n), reqBody))
+	}))
+	t.Cleanup(server.Close)
+	return server, &requests
+}
+
+const bashToolUseResponse = `{
+	"id": "msg_1",
+	"type": "message",
+	"role": "assistant",
+	"model": "claude-3-5-sonnet-20241022",
+	"content": [{"type": "tool_use", "id": "toolu_1", "name": "bash", "input": {"command": "true"}}],
+	"stop_reason": "tool_use",
+	"stop_sequence": null,
+	"usage": {"input_tokens": 1, "output_tokens": 1}
+}`
+
+func TestAnthropicExecutorMaxIterations(t *testing.T) {
+	server, requests := newStubAnthropicServer(t, func(int, []byte) string {
+		return bashToolUseResponse
+	})
+
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	executor, err := NewAnthropicExecutor(server.URL, "test-key", nil, logger, gitignore.CompileIgnoreLines(), GenConfig{
+		Model:         a.ModelClaude3_5Sonnet20241022,
+		MaxTokens:     1024,
+		MaxIterations: 3,
+	})
+	require.NoError(t, err)
+
+	assert.EqualError(t, executor.Execute("keep calling tools"), "stopped the agent after the maximum of 3 tool use iterations, before the model finished. Re-run with a higher -max-iterations, or a negative value for no limit")
+	assert.Equal(t, int32(3), requests.Load())
+}
+
+func TestCheckMaxIterations(t *testing.T) {
+	assert.NoError(t, checkMaxIterations(GenConfig{MaxIterations: 3}, 2))
+	assert.Error(t, checkMaxIterations(GenConfig{MaxIterations: 3}, 3))
+	assert.NoError(t, checkMaxIterations(GenConfig{MaxIterations: -1}, 1000), "a negative cap means no limit")
+}
+
+func TestAnthropicExecutorRepeatedToolCalls(t *testing.T) {
+	var warned bool
+	server, requests := newStubAnthropicServer(t, func(n int, reqBody []byte) string {
+		if strings.Contains(string(reqBody), "identical bash tool call 3 times in a row") {
+			warned = true
+		}
+		return bashToolUseResponse
+	})
+
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	executor, err := NewAnthropicExecutor(server.URL, "test-key", nil, logger, gitignore.CompileIgnoreLines(), GenConfig{
+		Model:          a.ModelClaude3_5Sonnet20241022,
+		MaxTokens:      1024,
+		MaxIterations:  50,
+		MaxToolRepeats: 3,
+	})
+	require.NoError(t, err)
+
+	require.NoError(t, executor.Execute("keep calling the same tool"))
+	assert.True(t, warned, "expected the model to be warned about repeating itself")
+	assert.Equal(t, int32(4), requests.Load())
+}
+
+func TestToolCallTracker(t *testing.T) {
+	tracker := newToolCallTracker(2)
+
+	assert.Equal(t, 1, tracker.track("bash", []byte(`{"command": "ls", "x": 1}`)))
+	// argument order and whitespace are not significant
+	repeats := tracker.track("bash", []byte(`{"x":1,"command":"ls"}`))
+	assert.Equal(t, 2, repeats)
+	assert.True(t, tracker.shouldWarn(repeats))
+	assert.False(t, tracker.shouldStop(repeats))
+	assert.True(t, tracker.shouldStop(tracker.track("bash", []byte(`{"command":"ls","x":1}`))))
+
+	// a different call resets the streak
+	assert.Equal(t, 1, tracker.track("bash", []byte(`{"command":"pwd"}`)))
+	assert.Equal(t, 1, tracker.track("files_overview", []byte(`{"command":"pwd"}`)))
+
+	disabled := newToolCallTracker(0)
+	for range 5 {
+		repeats = disabled.track("bash", []byte(`{}`))
+	}
+	assert.False(t, disabled.shouldWarn(repeats))
+	assert.False(t, disabled.shouldStop(repeats))
+}
+
+// capturingHandler records the message of every log record that passes its level check
+type capturingHandler struct {
+	level    slog.Level
+	mu       sync.Mutex
+	messages []string
+}
+
+func (h *capturingHandler) Enabled(_ context.Context, level slog.Level) bool {
+	return level >= h.level
+}
+
+func (h *capturingHandler) Handle(_ context.Context, r slog.Record) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.messages = append(h.messages, r.Message)
+	return nil
+}
+
+func (h *capturingHandler) WithAttrs([]slog.Attr) slog.Handler { return h }
+
+func (h *capturingHandler) WithGroup(string) slog.Handler { return h }
+
+func (h *capturingHandler) contains(substr string) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for _, msg := range h.messages {
+		if strings.Contains(msg, substr) {
+			return true
+		}
+	}
+	return false
+}
+
+func TestAnthropicExecutorToolResultLogLevel(t *testing.T) {
+	tests := []struct {
+		name         string
+		level        slog.Level
+		expectDumped bool
+	}{
+		{name: "info suppresses tool result dumps", level: slog.LevelInfo, expectDumped: false},
+		{name: "debug includes tool result dumps", level: slog.LevelDebug, expectDumped: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server, _ := newStubAnthropicServer(t, func(int, []byte) string {
+				return bashToolUseResponse
+			})
+
+			handler := &capturingHandler{level: tt.level}
+			executor, err := NewAnthropicExecutor(server.URL, "test-key", nil, slog.New(handler), gitignore.CompileIgnoreLines(), GenConfig{
+				Model:         a.ModelClaude3_5Sonnet20241022,
+				MaxTokens:     1024,
+				MaxIterations: 1,
+			})
+			require.NoError(t, err)
+
+			require.ErrorContains(t, executor.Execute("run a command"), "maximum of 1 tool use iterations")
+			assert.True(t, handler.contains("executing bash command"))
+			assert.Equal(t, tt.expectDumped, handler.contains("tool result:"))
+		})
+	}
+}
+
+func TestAnthropicExecutorLogsTurnDuration(t *testing.T) {
+	server, _ := newStubAnthropicServer(t, func(int, []byte) string {
+		return bashToolUseResponse
+	})
+
+	var buf bytes.Buffer
+	executor, err := NewAnthropicExecutor(server.URL, "test-key", nil, slog.New(slog.NewTextHandler(&buf, nil)), gitignore.CompileIgnoreLines(), GenConfig{
+		Model:         a.ModelClaude3_5Sonnet20241022,
+		MaxTokens:     1024,
+		MaxIterations: 2,
+	})
+	require.NoError(t, err)
+
+	require.ErrorContains(t, executor.Execute("run a command"), "maximum of 2 tool use iterations")
+	assert.Equal(t, 2, strings.Count(buf.String(), `msg="assistant turn completed" duration_ms=`))
+}
+
+const textResponse = `{
+	"id": "msg_2",
+	"type": "message",
+	"role": "assistant",
+	"model": "claude-3-5-sonnet-20241022",
+	"content": [{"type": "text", "text": "done"}],
+	"stop_reason": "end_turn",
+	"stop_sequence": null,
+	"usage": {"input_tokens": 1, "output_tokens": 1}
+}`
+
+func TestAnthropicExecutorContinuesConversation(t *testing.T) {
+	var bodies []string
+	server, _ := newStubAnthropicServer(t, func(_ int, reqBody []byte) string {
+		bodies = append(bodies, string(reqBody))
+		return textResponse
+	})
+
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	executor, err := NewAnthropicExecutor(server.URL, "test-key", nil, logger, gitignore.CompileIgnoreLines(), GenConfig{
+		Model:     a.ModelClaude3_5Sonnet20241022,
+		MaxTokens: 1024,
+	})
+	require.NoError(t, err)
+
+	require.NoError(t, executor.Execute("first question"))
+	require.NoError(t, executor.Execute("second question"))
+	require.Len(t, bodies, 2)
+
+	var req struct {
+		Messages []struct {
+			Role    string `json:"role"`
+			Content []struct {
+				Text         string          `json:"text"`
+				CacheControl json.RawMessage `json:"cache_control"`
+			} `json:"content"`
+		} `json:"messages"`
+	}
+	require.NoError(t, json.Unmarshal([]byte(bodies[1]), &req))
+	require.Len(t, req.Messages, 3)
+	assert.Equal(t, "first question", req.Messages[0].Content[0].Text)
+	assert.Equal(t, "assistant", req.Messages[1].Role)
+	assert.Equal(t, "second question", req.Messages[2].Content[0].Text)
+	// only the latest input sets a cache breakpoint
+	assert.Empty(t, req.Messages[0].Content[0].CacheControl)
+	assert.NotEmpty(t, req.Messages[2].Content[0].CacheControl)
+}
+
+func TestNormalizeAnthropicMessages(t *testing.T) {
+	text := func(role a.BetaMessageParamRole, texts ...string) a.BetaMessageParam {
+		var content []a.BetaContentBlockParamUnion
+		for _, s := range texts {
+			content = append(content, a.BetaTextBlockParam{Text: a.F(s), Type: a.F(a.BetaTextBlockParamTypeText)})
+		}
+		return a.BetaMessageParam{Role: a.F(role), Content: a.F(content)}
+	}
+	user, assistant := a.BetaMessageParamRoleUser, a.BetaMessageParamRoleAssistant
+
+	tests := []struct {
+		name     string
+		messages []a.BetaMessageParam
+		want     []a.BetaMessageParam
+	}{
+		{
+			name:     "alternating messages are unchanged",
+			messages: []a.BetaMessageParam{text(user, "hi"), text(assistant, "hello"), text(user, "bye")},
+			want:     []a.BetaMessageParam{text(user, "hi"), text(assistant, "hello"), text(user, "bye")},
+		},
+		{
+			name:     "consecutive user messages are merged",
+			messages: []a.BetaMessageParam{text(user, "hi"), text(assistant, "hello"), text(user, "first"), text(user, "second")},
+			want:     []a.BetaMessageParam{text(user, "hi"), text(assistant, "hello"), text(user, "first", "second")},
+		},
+		{
+			name:     "empty messages are dropped",
+			messages: []a.BetaMessageParam{text(user, "hi"), text(assistant), text(user, "again")},
+			want:     []a.BetaMessageParam{text(user, "hi", "again")},
+		},
+		{
+			name:     "leading assistant message gets a placeholder user message",
+			messages: []a.BetaMessageParam{text(assistant, "hello"), text(user, "hi")},
+			want:     []a.BetaMessageParam{text(user, placeholderUserMessage), text(assistant, "hello"), text(user, "hi")},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			original := slices.Clone(tt.messages)
+			assert.Equal(t, tt.want, normalizeAnthropicMessages(tt.messages))
+			assert.Equal(t, original, tt.messages, "the input messages must not be modified")
+		})
+	}
+}
+
+func TestAnthropicExecutorAlternatesRolesAfterStoppedRun(t *testing.T) {
+	var bodies [][]byte
+	server, _ := newStubAnthropicServer(t, func(n int, reqBody []byte) string {
+		bodies = append(bodies, reqBody)
+		if n == 1 {
+			return bashToolUseResponse
+		}
+		return textResponse
+	})
+
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	executor, err := NewAnthropicExecutor(server.URL, "test-key", nil, logger, gitignore.CompileIgnoreLines(), GenConfig{
+		Model:         a.ModelClaude3_5Sonnet20241022,
+		MaxTokens:     1024,
+		MaxIterations: 1,
+	})
+	require.NoError(t, err)
+
+	// the first run stops after the tool results, so the conversation ends with a user message
+	require.ErrorContains(t, executor.Execute("run a command"), "maximum of 1 tool use iterations")
+	require.NoError(t, executor.Execute("now summarize"))
+	require.Len(t, bodies, 2)
+
+	var req struct {
+		Messages []struct {
+			Role    string `json:"role"`
+			Content []struct {
+				Type string `json:"type"`
+				Text string `json:"text"`
+			} `json:"content"`
+		} `json:"messages"`
+	}
+	require.NoError(t, json.Unmarshal(bodies[1], &req))
+	var roles []string
+	for _, msg := range req.Messages {
+		roles = append(roles, msg.Role)
+	}
+	assert.Equal(t, []string{"user", "assistant", "user"}, roles)
+	last := req.Messages[2].Content
+	require.Len(t, last, 2)
+	assert.Equal(t, "tool_result", last[0].Type)
+	assert.Equal(t, "now summarize", last[1].Text)
+}
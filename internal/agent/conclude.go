@@ -0,0 +1,22 @@
+package agent
+
+import (
+	"log/slog"
+	"strings"
+)
+
+// concludePrompt asks the model for a final answer after it ended its turn without any text
+const concludePrompt = "You ended your turn without a response. Briefly summarize what you did and give your final answer."
+
+// maxConcludeRetries is the number of times the model is asked for a final answer during a single input
+const maxConcludeRetries = 1
+
+// needsConclusion reports whether the model ended its turn with the given text, but without any visible content,
+// and should be asked to conclude because it was asked fewer than maxConcludeRetries times so far
+func needsConclusion(logger *slog.Logger, text string, retries int) bool {
+	if strings.TrimSpace(text) != "" || retries >= maxConcludeRetries {
+		return false
+	}
+	logger.Warn("the model ended its turn without a response, asking it to conclude")
+	return true
+}
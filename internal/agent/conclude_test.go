@@ -0,0 +1,102 @@
+package agent
+
+import (
+	"encoding/json"
+	"io"
+	"log/slog"
+	"testing"
+
+	a "github.com/anthropics/anthropic-sdk-go"
+	gitignore "github.com/sabhiram/go-gitignore"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const emptyResponse = `{
+	"id": "msg_empty",
+	"type": "message",
+	"role": "assistant",
+	"model": "claude-3-5-sonnet-20241022",
+	"content": [],
+	"stop_reason": "end_turn",
+	"stop_sequence": null,
+	"usage": {"input_tokens": 1, "output_tokens": 0}
+}`
+
+func TestAnthropicExecutorAsksForConclusion(t *testing.T) {
+	server := newStubServer(t, bashToolUseResponse, emptyResponse, textResponse)
+
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	executor, err := NewAnthropicExecutor(server.URL, "test-key", nil, logger, gitignore.CompileIgnoreLines(), GenConfig{
+		Model:     a.ModelClaude3_5Sonnet20241022,
+		MaxTokens: 1024,
+	})
+	require.NoError(t, err)
+	require.NoError(t, executor.Execute("run true"))
+
+	requests := server.requests()
+	require.Len(t, requests, 3)
+	assert.Equal(t, "done", FinalResponse(executor))
+
+	var last struct {
+		Messages []struct {
+			Role    string `json:"role"`
+			Content []struct {
+				Type string `json:"type"`
+				Text string `json:"text"`
+			} `json:"content"`
+		} `json:"messages"`
+	}
+	require.NoError(t, json.Unmarshal([]byte(requests[2]), &last))
+	msg := last.Messages[len(last.Messages)-1]
+	assert.Equal(t, "user", msg.Role)
+	require.NotEmpty(t, msg.Content)
+	assert.Equal(t, concludePrompt, msg.Content[len(msg.Content)-1].Text)
+}
+
+func TestOpenAIExecutorAsksForConclusion(t *testing.T) {
+	tests := []struct {
+		name         string
+		responses    []string
+		wantRequests int
+		wantResponse string
+	}{
+		{
+			name:         "empty then answer",
+			responses:    []string{openaiContentResponse(""), openaiContentResponse("done")},
+			wantRequests: 2,
+			wantResponse: "done",
+		},
+		{
+			name:         "asks only once",
+			responses:    []string{openaiContentResponse(""), openaiContentResponse(" "), openaiContentResponse("never requested")},
+			wantRequests: 2,
+			wantResponse: " ",
+		},
+		{
+			name:         "answer without asking",
+			responses:    []string{openaiContentResponse("done")},
+			wantRequests: 1,
+			wantResponse: "done",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := newStubServer(t, tt.responses...)
+
+			logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+			executor, err := NewOpenAIExecutor(server.URL, "test-key", nil, logger, gitignore.CompileIgnoreLines(), GenConfig{Model: "gpt-4o"})
+			require.NoError(t, err)
+			require.NoError(t, executor.Execute("what does main.go do?"))
+
+			requests := server.requests()
+			require.Len(t, requests, tt.wantRequests)
+			assert.Equal(t, tt.wantResponse, FinalResponse(executor))
+			assert.NotContains(t, requests[0], concludePrompt)
+			if tt.wantRequests > 1 {
+				assert.Contains(t, requests[1], concludePrompt)
+			}
+		})
+	}
+}
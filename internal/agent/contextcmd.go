@@ -0,0 +1,61 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// contextCommandRunner runs a command in dir, stopping it after timeout, and returns its stdout
+type contextCommandRunner func(dir, command string, timeout time.Duration) (string, error)
+
+// contextCommandTimeout is how long the context command may run before it is stopped
+const contextCommandTimeout = 30 * time.Second
+
+// maxContextCommandOutput is the number of bytes of the context command's output added to the system prompt
+const maxContextCommandOutput = 10000
+
+// runContextCommand runs command with the command shell
+func runContextCommand(dir, command string, timeout time.Duration) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	args := shellCommandArgs(commandShell, command)
+	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+	cmd.Dir = dir
+	cmd.Env = os.Environ()
+	// child processes of the shell can keep the output open after it is killed
+	cmd.WaitDelay = time.Second
+
+	out, err := cmd.Output()
+	var exitErr *exec.ExitError
+	switch {
+	case errors.Is(ctx.Err(), context.DeadlineExceeded):
+		return "", fmt.Errorf("timed out after %s", timeout)
+	case errors.As(err, &exitErr) && len(exitErr.Stderr) > 0:
+		return "", fmt.Errorf("%w: %s", err, strings.TrimSpace(string(exitErr.Stderr)))
+	case err != nil:
+		return "", err
+	}
+	return string(out), nil
+}
+
+// projectContext runs the user's context command in dir and returns its output, truncated to
+// maxContextCommandOutput bytes. A failing command is logged as a warning and skipped, returning an empty string
+func projectContext(logger *slog.Logger, run contextCommandRunner, dir, command string) string {
+	out, err := run(dir, command, contextCommandTimeout)
+	if err != nil {
+		logger.Warn("skipping the output of the context command", slog.String("command", command), slog.Any("err", err))
+		return ""
+	}
+	out = strings.TrimSpace(out)
+	if len(out) > maxContextCommandOutput {
+		out = out[:maxContextCommandOutput] + "\n... (output truncated)"
+	}
+	return out
+}
@@ -0,0 +1,67 @@
+package agent
+
+import (
+	"errors"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestProjectContext(t *testing.T) {
+	handler := &capturingHandler{level: slog.LevelWarn}
+	logger := slog.New(handler)
+
+	var gotDir, gotCommand string
+	run := func(dir, command string, timeout time.Duration) (string, error) {
+		gotDir, gotCommand = dir, command
+		assert.Equal(t, contextCommandTimeout, timeout)
+		return "Architecture:\n- cmd/ holds the CLI\n\n", nil
+	}
+	assert.Equal(t, "Architecture:\n- cmd/ holds the CLI", projectContext(logger, run, "project", "make context"))
+	assert.Equal(t, "project", gotDir)
+	assert.Equal(t, "make context", gotCommand)
+	assert.False(t, handler.contains("skipping"))
+
+	failing := func(string, string, time.Duration) (string, error) {
+		return "partial output", errors.New("exit status 2: make: *** No rule to make target 'context'")
+	}
+	assert.Empty(t, projectContext(logger, failing, ".", "make context"))
+	assert.True(t, handler.contains("skipping the output of the context command"))
+
+	long := func(string, string, time.Duration) (string, error) {
+		return strings.Repeat("x", maxContextCommandOutput+10), nil
+	}
+	assert.Equal(t, strings.Repeat("x", maxContextCommandOutput)+"\n... (output truncated)", projectContext(logger, long, ".", "cat notes"))
+}
+
+func TestRunContextCommand(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("uses POSIX shell commands")
+	}
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "ARCHITECTURE.md"), []byte("notes"), 0644))
+
+	out, err := runContextCommand(dir, "cat ARCHITECTURE.md; echo ignored >&2", time.Minute)
+	require.NoError(t, err)
+	assert.Equal(t, "notes", out)
+
+	_, err = runContextCommand(dir, "echo broken >&2; exit 2", time.Minute)
+	assert.ErrorContains(t, err, "exit status 2: broken")
+
+	_, err = runContextCommand(dir, "sleep 5", 100*time.Millisecond)
+	assert.ErrorContains(t, err, "timed out after 100ms")
+}
+
+func TestSystemPromptForProjectContext(t *testing.T) {
+	prompt := systemPromptFor(GenConfig{RepoContext: "Current branch: main", ProjectContext: "Use make for everything"})
+	assert.True(t, strings.HasPrefix(prompt, systemPrompt))
+	assert.Contains(t, prompt, "Context about the project provided by the user:\nUse make for everything")
+	assert.Less(t, strings.Index(prompt, "Current branch: main"), strings.Index(prompt, "Use make for everything"))
+}
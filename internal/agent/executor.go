@@ -8,6 +8,7 @@ import (
 	"log/slog"
 	"os"
 	"strings"
+	"time"
 )
 
 //go:embed agent_instructions.txt
@@ -15,12 +16,21 @@ var agentInstructions string
 
 // Executor defines the interface for executing agentic workflows
 type Executor interface {
+	// Execute runs the agent loop for the given user input. Subsequent calls continue the same conversation
 	Execute(input string) error
 }
 
 // InitExecutor initializes and returns an appropriate executor based on the model configuration
 func InitExecutor(logger *slog.Logger, flags ModelOptions) (Executor, error) {
-	ignorer, err := ignore.LoadIgnoreFiles(".")
+	dir := "."
+	if flags.WorkDir != "" {
+		if err := validateWorkDir(flags.WorkDir); err != nil {
+			return nil, err
+		}
+		dir = flags.WorkDir
+	}
+
+	ignorer, err := ignore.LoadIgnoreFiles(dir)
 	if err != nil {
 		return nil, fmt.Errorf("failed to load ignore files: %w", err)
 	}
@@ -36,11 +46,25 @@ func InitExecutor(logger *slog.Logger, flags ModelOptions) (Executor, error) {
 	if envURL := os.Getenv("CPE_CUSTOM_URL"); customURL == "" && envURL != "" {
 		customURL = envURL
 	}
+	flags.CustomURL = customURL
 
 	genConfig, err := GetConfig(logger, flags)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get provider: %w", err)
 	}
+	warnUnknownToolDescriptions(logger, genConfig.ToolDescriptions)
+	genConfig.TestCommand = resolveTestCommand(dir, genConfig.TestCommand)
+	if flags.GitContext {
+		genConfig.RepoContext = gitContext(gitIn(dir))
+	}
+	if flags.ContextCommand != "" {
+		genConfig.ProjectContext = projectContext(logger, runContextCommand, dir, flags.ContextCommand)
+	}
+
+	httpClient, err := newHTTPClient()
+	if err != nil {
+		return nil, err
+	}
 
 	// Check if we have a specific executor for this model
 	switch genConfig.Model {
@@ -49,24 +73,40 @@ func InitExecutor(logger *slog.Logger, flags ModelOptions) (Executor, error) {
 		if apiKey == "" {
 			return nil, fmt.Errorf("DEEPSEEK_API_KEY environment variable not set")
 		}
-		return NewDeepSeekExecutor(customURL, apiKey, logger, ignorer, genConfig), nil
+		return NewDeepSeekExecutor(customURL, apiKey, httpClient, logger, ignorer, genConfig)
 	case anthropic.ModelClaude3_5Sonnet20241022, anthropic.ModelClaude3_5Haiku20241022, anthropic.ModelClaude_3_Haiku_20240307, anthropic.ModelClaude_3_Opus_20240229:
 		apiKey := os.Getenv("ANTHROPIC_API_KEY")
 		if apiKey == "" {
 			return nil, fmt.Errorf("ANTHROPIC_API_KEY environment variable not set")
 		}
-		return NewAnthropicExecutor(customURL, apiKey, logger, ignorer, genConfig), nil
+		return NewAnthropicExecutor(customURL, apiKey, httpClient, logger, ignorer, genConfig)
 	case "gemini-1.5-pro-002", "gemini-1.5-flash-002", "gemini-2.0-flash-exp":
 		apiKey := os.Getenv("GEMINI_API_KEY")
 		if apiKey == "" {
 			return nil, fmt.Errorf("GEMINI_API_KEY environment variable not set")
 		}
-		return NewGeminiExecutor(customURL, apiKey, logger, ignorer, genConfig)
+		return NewGeminiExecutor(customURL, apiKey, httpClient, logger, ignorer, genConfig)
 	default:
 		apiKey := os.Getenv("OPENAI_API_KEY")
 		if apiKey == "" {
 			return nil, fmt.Errorf("OPENAI_API_KEY environment variable not set")
 		}
-		return NewOpenAIExecutor(customURL, apiKey, logger, ignorer, genConfig), nil
+		return NewOpenAIExecutor(customURL, apiKey, httpClient, logger, ignorer, genConfig)
+	}
+}
+
+// checkMaxIterations returns an error explaining why the agent stopped once the agent loop has used up the
+// configured number of tool use turns, so the run does not appear to have finished. A MaxIterations of 0 or less
+// disables the cap
+func checkMaxIterations(config GenConfig, iterations int) error {
+	if config.MaxIterations <= 0 || iterations < config.MaxIterations {
+		return nil
 	}
+	return fmt.Errorf("stopped the agent after the maximum of %d tool use iterations, before the model finished. "+
+		"Re-run with a higher -max-iterations, or a negative value for no limit", config.MaxIterations)
+}
+
+// logTurnDuration logs the wall-clock time a single assistant turn (provider call) took, measured from start
+func logTurnDuration(logger *slog.Logger, start time.Time) {
+	logger.Info("assistant turn completed", slog.Int64("duration_ms", time.Since(start).Milliseconds()))
 }
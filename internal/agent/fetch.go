@@ -0,0 +1,160 @@
+package agent
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"golang.org/x/net/html"
+)
+
+var fetchURLTool = Tool{
+	Name: "fetch_url",
+	Description: `A tool to download a web page, e.g. documentation, and return its readable text
+* Scripts, styles, navigation and other page boilerplate are stripped from HTML pages
+* Only http and https URLs on the domains the user has allowed can be fetched
+* Long pages are truncated`,
+	InputSchema: map[string]interface{}{
+		"type": "object",
+		"properties": map[string]interface{}{
+			"url": map[string]interface{}{
+				"type":        "string",
+				"description": "The http or https URL to fetch.",
+			},
+		},
+		"required": []string{"url"},
+	},
+}
+
+// maxFetchBytes caps the size of a page body that is downloaded
+const maxFetchBytes = 5 << 20
+
+// maxFetchTextLength caps the length of the text returned to the model
+const maxFetchTextLength = 50000
+
+// fetchClient is the HTTP client used by the fetch_url tool
+var fetchClient = &http.Client{Timeout: 30 * time.Second}
+
+// domainAllowed reports whether host is one of the allowed domains or a subdomain of one
+func domainAllowed(host string, allowed []string) bool {
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	return slices.ContainsFunc(allowed, func(domain string) bool {
+		domain = strings.ToLower(strings.TrimSuffix(domain, "."))
+		return host == domain || strings.HasSuffix(host, "."+domain)
+	})
+}
+
+// checkFetchURL returns an error if the URL may not be fetched
+func checkFetchURL(u *url.URL, allowed []string) error {
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("unsupported URL scheme %q, only http and https are allowed", u.Scheme)
+	}
+	if !domainAllowed(u.Hostname(), allowed) {
+		return fmt.Errorf("domain %s is not in the list of allowed domains (%s)", u.Hostname(), strings.Join(allowed, ", "))
+	}
+	return nil
+}
+
+// executeFetchURLTool downloads the page at rawURL, which must be on one of the allowed domains, and returns its
+// readable text, truncated to maxLength characters. Redirects are only followed to allowed domains
+func executeFetchURLTool(client *http.Client, rawURL string, allowed []string, maxLength int) (*ToolResult, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return &ToolResult{Content: fmt.Sprintf("Error parsing URL: %s", err), IsError: true}, nil
+	}
+	if err := checkFetchURL(u, allowed); err != nil {
+		return &ToolResult{Content: fmt.Sprintf("Error fetching URL: %s", err), IsError: true}, nil
+	}
+
+	c := *client
+	c.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+		if len(via) >= 10 {
+			return errors.New("stopped after 10 redirects")
+		}
+		return checkFetchURL(req.URL, allowed)
+	}
+	resp, err := c.Get(u.String())
+	if err != nil {
+		return &ToolResult{Content: fmt.Sprintf("Error fetching URL: %s", err), IsError: true}, nil
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return &ToolResult{Content: fmt.Sprintf("Error fetching URL: unexpected status %s", resp.Status), IsError: true}, nil
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxFetchBytes))
+	if err != nil {
+		return &ToolResult{Content: fmt.Sprintf("Error reading response: %s", err), IsError: true}, nil
+	}
+
+	text := string(body)
+	if mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type")); mediaType == "text/html" || mediaType == "application/xhtml+xml" {
+		text, err = extractText(string(body))
+		if err != nil {
+			return &ToolResult{Content: fmt.Sprintf("Error parsing HTML: %s", err), IsError: true}, nil
+		}
+	}
+
+	if runes := []rune(text); len(runes) > maxLength {
+		text = string(runes[:maxLength]) + "\n\n[truncated]"
+	}
+	return &ToolResult{Content: text}, nil
+}
+
+// skippedElements are the HTML elements whose contents are not readable page text
+var skippedElements = []string{"script", "style", "noscript", "template", "svg", "iframe", "head", "nav", "header", "footer", "aside", "form"}
+
+// blockElements are the HTML elements that start a new line of text
+var blockElements = []string{"p", "div", "section", "article", "main", "br", "li", "tr", "pre", "blockquote", "h1", "h2", "h3", "h4", "h5", "h6", "dt", "dd", "table", "ul", "ol"}
+
+// extractText returns the readable text of an HTML document, one line per block element
+func extractText(document string) (string, error) {
+	root, err := html.Parse(strings.NewReader(document))
+	if err != nil {
+		return "", err
+	}
+
+	var sb strings.Builder
+	var walk func(n *html.Node, pre bool)
+	walk = func(n *html.Node, pre bool) {
+		if n.Type == html.ElementNode {
+			if slices.Contains(skippedElements, n.Data) {
+				return
+			}
+			pre = pre || n.Data == "pre"
+		}
+		if n.Type == html.TextNode {
+			if pre {
+				sb.WriteString(n.Data)
+			} else if text := strings.Join(strings.Fields(n.Data), " "); text != "" {
+				sb.WriteString(text)
+				sb.WriteString(" ")
+			}
+		}
+		block := n.Type == html.ElementNode && slices.Contains(blockElements, n.Data)
+		if block {
+			sb.WriteString("\n")
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			walk(c, pre)
+		}
+		if block {
+			sb.WriteString("\n")
+		}
+	}
+	walk(root, false)
+
+	var lines []string
+	for _, line := range strings.Split(sb.String(), "\n") {
+		if line = strings.TrimRight(line, " \t"); strings.TrimSpace(line) != "" {
+			lines = append(lines, line)
+		}
+	}
+	return strings.Join(lines, "\n"), nil
+}
@@ -0,0 +1,132 @@
+package agent
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const docsPage = `<!DOCTYPE html>
+<html>
+<head><title>Docs</title><style>body { color: red; }</style></head>
+<body>
+<nav><a href="/">Home</a> | <a href="/about">About</a></nav>
+<main>
+<h1>Getting   started</h1>
+<p>Install the tool with <code>go install</code>.</p>
+<script>console.log("tracking");</script>
+<ul><li>First</li><li>Second</li></ul>
+<pre>cpe -version
+cpe -help</pre>
+</main>
+<footer>Copyright</footer>
+</body>
+</html>`
+
+func TestExecuteFetchURLTool(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/docs":
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			io.WriteString(w, docsPage)
+		case "/plain":
+			w.Header().Set("Content-Type", "text/plain")
+			io.WriteString(w, strings.Repeat("a", 100))
+		case "/redirect":
+			http.Redirect(w, r, "http://localhost.invalid/elsewhere", http.StatusFound)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+	allowed := []string{"127.0.0.1"}
+
+	tests := []struct {
+		name      string
+		url       string
+		allowed   []string
+		maxLength int
+		expected  string
+		isError   bool
+	}{
+		{
+			name:      "readable text is extracted from html",
+			url:       server.URL + "/docs",
+			allowed:   allowed,
+			maxLength: maxFetchTextLength,
+			expected:  "Getting started\nInstall the tool with go install .\nFirst\nSecond\ncpe -version\ncpe -help",
+		},
+		{
+			name:      "text is capped at the size limit",
+			url:       server.URL + "/plain",
+			allowed:   allowed,
+			maxLength: 10,
+			expected:  "aaaaaaaaaa\n\n[truncated]",
+		},
+		{
+			name:      "domain not in the allow-list",
+			url:       server.URL + "/docs",
+			allowed:   []string{"go.dev"},
+			maxLength: maxFetchTextLength,
+			expected:  "Error fetching URL: domain 127.0.0.1 is not in the list of allowed domains (go.dev)",
+			isError:   true,
+		},
+		{
+			name:      "redirect to a domain not in the allow-list",
+			url:       server.URL + "/redirect",
+			allowed:   allowed,
+			maxLength: maxFetchTextLength,
+			expected:  `Error fetching URL: Get "http://localhost.invalid/elsewhere": domain localhost.invalid is not in the list of allowed domains (127.0.0.1)`,
+			isError:   true,
+		},
+		{
+			name:      "unsupported scheme",
+			url:       "file:///etc/passwd",
+			allowed:   allowed,
+			maxLength: maxFetchTextLength,
+			expected:  `Error fetching URL: unsupported URL scheme "file", only http and https are allowed`,
+			isError:   true,
+		},
+		{
+			name:      "error status",
+			url:       server.URL + "/missing",
+			allowed:   allowed,
+			maxLength: maxFetchTextLength,
+			expected:  "Error fetching URL: unexpected status 404 Not Found",
+			isError:   true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := executeFetchURLTool(server.Client(), tt.url, tt.allowed, tt.maxLength)
+			require.NoError(t, err)
+			assert.Equal(t, tt.isError, result.IsError)
+			assert.Equal(t, tt.expected, result.Content)
+		})
+	}
+}
+
+func TestDomainAllowed(t *testing.T) {
+	allowed := []string{"go.dev", "Example.com."}
+
+	assert.True(t, domainAllowed("go.dev", allowed))
+	assert.True(t, domainAllowed("pkg.go.dev", allowed))
+	assert.True(t, domainAllowed("www.example.com", allowed))
+	assert.False(t, domainAllowed("notgo.dev", allowed))
+	assert.False(t, domainAllowed("go.dev.evil.com", allowed))
+	assert.False(t, domainAllowed("go.dev", nil))
+}
+
+func TestToolEnabled(t *testing.T) {
+	assert.False(t, toolEnabled(GenConfig{}, fetchURLTool.Name))
+	assert.True(t, toolEnabled(GenConfig{FetchDomains: []string{"go.dev"}}, fetchURLTool.Name))
+	assert.True(t, toolEnabled(GenConfig{ReadOnly: true, FetchDomains: []string{"go.dev"}}, fetchURLTool.Name))
+	assert.False(t, toolEnabled(GenConfig{ReadOnly: true}, applyPatchTool.Name))
+	assert.True(t, toolEnabled(GenConfig{}, bashTool.Name))
+}
@@ -10,6 +10,8 @@ import (
 	"google.golang.org/api/googleapi"
 	"google.golang.org/api/option"
 	"log/slog"
+	"net/http"
+	"slices"
 	"strings"
 	"time"
 )
@@ -38,9 +40,32 @@ type geminiExecutor struct {
 	logger  *slog.Logger
 	ignorer *gitignore.GitIgnore
 	config  GenConfig
+	// tokenizer counts the tokens of the input before it is sent
+	tokenizer Tokenizer
+	// session holds the chat history, so that subsequent calls to Execute continue the conversation
+	session *genai.ChatSession
+	// response is the text of the model's final response to the last input
+	response string
 }
 
-func NewGeminiExecutor(baseUrl string, apiKey string, logger *slog.Logger, ignorer *gitignore.GitIgnore, config GenConfig) (Executor, error) {
+// geminiKeyTransport is an http.RoundTripper that authenticates requests with the Gemini API key. The Gemini client
+// ignores option.WithAPIKey when an HTTP client is given, so the key has to be set by the transport
+type geminiKeyTransport struct {
+	next   http.RoundTripper
+	apiKey string
+}
+
+func (t *geminiKeyTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	req.Header.Set("X-Goog-Api-Key", t.apiKey)
+	return t.next.RoundTrip(req)
+}
+
+func NewGeminiExecutor(baseUrl string, apiKey string, httpClient *http.Client, logger *slog.Logger, ignorer *gitignore.GitIgnore, config GenConfig) (Executor, error) {
+	if err := validateGenConfig(geminiLimits, config); err != nil {
+		return nil, fmt.Errorf("invalid generation config: %w", err)
+	}
+
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
 	defer cancel()
 
@@ -48,6 +73,16 @@ func NewGeminiExecutor(baseUrl string, apiKey string, logger *slog.Logger, ignor
 	if baseUrl != "" {
 		opts = append(opts, option.WithEndpoint(baseUrl))
 	}
+	if httpClient != nil {
+		next := httpClient.Transport
+		if next == nil {
+			next = http.DefaultTransport
+		}
+		opts = append(opts, option.WithHTTPClient(&http.Client{
+			Transport: &geminiKeyTransport{next: next, apiKey: apiKey},
+			Timeout:   httpClient.Timeout,
+		}))
+	}
 
 	client, err := genai.NewClient(ctx, opts...)
 	if err != nil {
@@ -56,7 +91,9 @@ func NewGeminiExecutor(baseUrl string, apiKey string, logger *slog.Logger, ignor
 
 	model := client.GenerativeModel(config.Model)
 	model.SetTemperature(config.Temperature)
-	model.SetMaxOutputTokens(int32(config.MaxTokens))
+	if config.MaxTokens > 0 {
+		model.SetMaxOutputTokens(int32(config.MaxTokens))
+	}
 
 	if config.TopK != nil {
 		model.SetTopK(int32(*config.TopK))
@@ -77,7 +114,7 @@ func NewGeminiExecutor(baseUrl string, apiKey string, logger *slog.Logger, ignor
 						Properties: map[string]*genai.Schema{
 							"command": {
 								Type:        genai.TypeString,
-								Description: "The bash command to run.",
+								Description: "The command to run.",
 							},
 						},
 						Required: []string{"command"},
@@ -117,6 +154,15 @@ func NewGeminiExecutor(baseUrl string, apiKey string, logger *slog.Logger, ignor
 				{
 					Name:        filesOverviewTool.Name,
 					Description: filesOverviewTool.Description,
+					Parameters: &genai.Schema{
+						Type: genai.TypeObject,
+						Properties: map[string]*genai.Schema{
+							"full": {
+								Type:        genai.TypeBoolean,
+								Description: "List every file with its contents even when there are a lot of files.",
+							},
+						},
+					},
 				},
 				{
 					Name:        getRelatedFilesTool.Name,
@@ -135,25 +181,137 @@ func NewGeminiExecutor(baseUrl string, apiKey string, logger *slog.Logger, ignor
 						Required: []string{"input_files"},
 					},
 				},
+				{
+					Name:        applyPatchTool.Name,
+					Description: applyPatchTool.Description,
+					Parameters: &genai.Schema{
+						Type: genai.TypeObject,
+						Properties: map[string]*genai.Schema{
+							"patch": {
+								Type:        genai.TypeString,
+								Description: "The unified diff to apply.",
+							},
+						},
+						Required: []string{"patch"},
+					},
+				},
+				{
+					Name:        fetchURLTool.Name,
+					Description: fetchURLTool.Description,
+					Parameters: &genai.Schema{
+						Type: genai.TypeObject,
+						Properties: map[string]*genai.Schema{
+							"url": {
+								Type:        genai.TypeString,
+								Description: "The http or https URL to fetch.",
+							},
+						},
+						Required: []string{"url"},
+					},
+				},
+				{
+					Name:        runTestsTool.Name,
+					Description: runTestsTool.Description,
+				},
+				{
+					Name:        readSymbolTool.Name,
+					Description: readSymbolTool.Description,
+					Parameters: &genai.Schema{
+						Type: genai.TypeObject,
+						Properties: map[string]*genai.Schema{
+							"path": {
+								Type:        genai.TypeString,
+								Description: `Relative path to the source file, e.g. "./internal/agent/tools.go"`,
+							},
+							"symbol": {
+								Type:        genai.TypeString,
+								Description: "The name of the function, method, type, constant or variable to read.",
+							},
+						},
+						Required: []string{"path", "symbol"},
+					},
+				},
+				{
+					Name:        gitDiffTool.Name,
+					Description: gitDiffTool.Description,
+					Parameters: &genai.Schema{
+						Type: genai.TypeObject,
+						Properties: map[string]*genai.Schema{
+							"ref": {
+								Type:        genai.TypeString,
+								Description: `The commit, branch or tag to compare the working tree against (default "HEAD").`,
+							},
+							"paths": {
+								Type:        genai.TypeArray,
+								Description: `Relative paths of files or directories to limit the diff to, e.g. ["./internal/agent"]`,
+								Items: &genai.Schema{
+									Type: genai.TypeString,
+								},
+							},
+						},
+					},
+				},
 			},
 		},
 	}
 
+	model.Tools[0].FunctionDeclarations = slices.DeleteFunc(model.Tools[0].FunctionDeclarations, func(decl *genai.FunctionDeclaration) bool {
+		return !toolEnabled(config, decl.Name)
+	})
+	for _, decl := range model.Tools[0].FunctionDeclarations {
+		decl.Description = toolDescription(config, decl.Name, decl.Description)
+	}
+
 	// Set system prompt
 	model.SystemInstruction = &genai.Content{
-		Parts: []genai.Part{genai.Text(agentInstructions)},
+		Parts: []genai.Part{genai.Text(systemPromptFor(config))},
 	}
 
 	return &geminiExecutor{
-		model:   model,
-		logger:  logger,
-		ignorer: ignorer,
-		config:  config,
+		model:     model,
+		logger:    logger,
+		ignorer:   ignorer,
+		config:    config,
+		tokenizer: selectTokenizer(config.Model, nil, logger),
 	}, nil
 }
 
+// geminiHistory returns the conversation kept by session, or nil before the first input
+func geminiHistory(session *genai.ChatSession) []*genai.Content {
+	if session == nil {
+		return nil
+	}
+	return session.History
+}
+
+// answerFunctionCalls ends the kept conversation with the responses to the model's last function calls when Execute
+// returns without sending them, since Gemini rejects a conversation where a function call is not followed by its response
+func answerFunctionCalls(session *genai.ChatSession, responses []genai.Part) {
+	session.History = append(session.History, genai.NewUserContent(responses...))
+}
+
+// stoppedResponses answers every function call in parts with reason, for calls the agent stopped before running
+func stoppedResponses(parts []genai.Part, reason string) []genai.Part {
+	var responses []genai.Part
+	for _, part := range parts {
+		if call, ok := part.(genai.FunctionCall); ok {
+			responses = append(responses, genai.FunctionResponse{
+				Name:     call.Name,
+				Response: map[string]any{"result": reason, "error": true},
+			})
+		}
+	}
+	return responses
+}
+
 func (g *geminiExecutor) Execute(input string) error {
-	session := g.model.StartChat()
+	if err := checkInputSize(g.tokenizer, g.config, systemPromptFor(g.config), geminiHistory(g.session), input); err != nil {
+		return err
+	}
+	if g.session == nil {
+		g.session = g.model.StartChat()
+	}
+	session := g.session
 
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
 	defer cancel()
@@ -165,6 +323,7 @@ func (g *geminiExecutor) Execute(input string) error {
 	retryCount := 0
 	retryWait := 1 * time.Minute
 
+	turnStart := time.Now()
 	for retryCount <= maxRetries {
 		resp, err = session.SendMessage(ctx, genai.Text(input))
 		if err == nil {
@@ -191,14 +350,21 @@ func (g *geminiExecutor) Execute(input string) error {
 		}
 		return fmt.Errorf("error sending message to Gemini: %w", err)
 	}
+	logTurnDuration(g.logger, turnStart)
 
-	for {
+	g.response = ""
+	tracker := newToolCallTracker(g.config.MaxToolRepeats)
+	cache := newToolCache()
+	concludeRetries := 0
+	for iterations := 1; ; iterations++ {
 		if len(resp.Candidates) == 0 || len(resp.Candidates[0].Content.Parts) == 0 {
 			return fmt.Errorf("no response generated")
 		}
 
 		finished := true
 		var nextMsg []genai.Part
+		var calls []toolInvocation
+		var text []string
 
 		for _, part := range resp.Candidates[0].Content.Parts {
 			switch v := part.(type) {
@@ -207,97 +373,92 @@ func (g *geminiExecutor) Execute(input string) error {
 					continue
 				}
 				g.logger.Info(string(v))
+				text = append(text, string(v))
 			case genai.FunctionCall:
 				finished = false
 				g.logger.Info(fmt.Sprintf("Tool: %s", v.Name))
 
-				var result *ToolResult
-				switch v.Name {
-				case bashTool.Name:
-					var bashToolInput struct {
-						Command string `json:"command"`
-					}
-					jsonInput, marshalErr := json.Marshal(v.Args)
-					if marshalErr != nil {
-						return fmt.Errorf("failed to marshal bash tool input: %w", marshalErr)
-					}
-					if err := json.Unmarshal(jsonInput, &bashToolInput); err != nil {
-						return fmt.Errorf("failed to unmarshal bash tool arguments: %w", err)
-					}
-					g.logger.Info(fmt.Sprintf("executing bash command: %s", bashToolInput.Command))
-					result, err = executeBashTool(bashToolInput.Command)
-				case fileEditor.Name:
-					var fileEditorToolInput FileEditorParams
-					jsonInput, marshalErr := json.Marshal(v.Args)
-					if marshalErr != nil {
-						return fmt.Errorf("failed to marshal file editor tool input: %w", marshalErr)
-					}
-					if err := json.Unmarshal(jsonInput, &fileEditorToolInput); err != nil {
-						return fmt.Errorf("failed to unmarshal file editor tool arguments: %w", err)
-					}
-					g.logger.Info("executing file editor tool",
-						slog.String("command", fileEditorToolInput.Command),
-						slog.String("path", fileEditorToolInput.Path),
-					)
-					g.logger.Info(fmt.Sprintf("old_str:\n%s\n\nnew_str:\n%s", fileEditorToolInput.OldStr, fileEditorToolInput.NewStr))
-					result, err = executeFileEditorTool(fileEditorToolInput)
-				case filesOverviewTool.Name:
-					g.logger.Info("executing files overview tool")
-					result, err = executeFilesOverviewTool(g.ignorer)
-				case getRelatedFilesTool.Name:
-					var relatedFilesToolInput struct {
-						InputFiles []string `json:"input_files"`
-					}
-					jsonInput, marshalErr := json.Marshal(v.Args)
-					if marshalErr != nil {
-						return fmt.Errorf("failed to marshal get related files tool input: %w", marshalErr)
-					}
-					if err := json.Unmarshal(jsonInput, &relatedFilesToolInput); err != nil {
-						return fmt.Errorf("failed to unmarshal get related files tool arguments: %w", err)
-					}
-					g.logger.Info("getting related files", slog.Any("input_files", relatedFilesToolInput.InputFiles))
-					result, err = executeGetRelatedFilesTool(relatedFilesToolInput.InputFiles, g.ignorer)
-				default:
-					return fmt.Errorf("unexpected tool name: %s", v.Name)
+				rawArgs, marshalErr := json.Marshal(v.Args)
+				if marshalErr != nil {
+					return fmt.Errorf("failed to marshal %s tool input: %w", v.Name, marshalErr)
 				}
-
-				if err != nil {
-					return fmt.Errorf("failed to execute tool %s: %w", v.Name, err)
+				repeats := tracker.track(v.Name, rawArgs)
+				if tracker.shouldStop(repeats) {
+					reason := fmt.Sprintf("stopping agent: the model repeated the identical %s tool call %d times in a row", v.Name, repeats)
+					g.logger.Warn(reason)
+					answerFunctionCalls(session, stoppedResponses(resp.Candidates[0].Content.Parts, reason))
+					return nil
 				}
+				calls = append(calls, toolInvocation{
+					name:    v.Name,
+					input:   rawArgs,
+					repeats: repeats,
+				})
+			}
+		}
 
-				resultStr := fmt.Sprintf("tool result: %+v", result.Content)
-				if len(resultStr) > 10000 {
-					resultStr = resultStr[:10000] + "..."
-				}
-				g.logger.Info(resultStr)
-
-				// Convert tool result to function response
-				var response map[string]any
-				switch content := result.Content.(type) {
-				case string:
-					response = map[string]any{"result": content}
-				case map[string]interface{}:
-					response = content
-				default:
-					panic("unexpected type")
-				}
-				if result.IsError {
-					response["error"] = true
-				}
+		var results []*ToolResult
+		results, err = runToolCalls(calls, g.config.MaxParallelTools, func(call toolInvocation) (*ToolResult, error) {
+			return cache.run(call, func() (*ToolResult, error) {
+				return executeTool(g.logger, g.ignorer, g.config, call.name, call.input)
+			})
+		})
+		if err != nil {
+			return err
+		}
 
-				nextMsg = append(nextMsg, genai.FunctionResponse{
-					Name:     v.Name,
-					Response: response,
-				})
+		for i, call := range calls {
+			result := results[i]
+			resultStr := fmt.Sprintf("tool result: %+v", result.Content)
+			if len(resultStr) > 10000 {
+				resultStr = resultStr[:10000] + "..."
 			}
+			g.logger.Debug(resultStr)
+			if tracker.shouldWarn(call.repeats) {
+				result.Content = fmt.Sprintf("%v\n\n%s", result.Content, tracker.repetitionWarning(call.name))
+			}
+
+			// Convert tool result to function response
+			var response map[string]any
+			switch content := result.Content.(type) {
+			case string:
+				response = map[string]any{"result": content}
+			case map[string]interface{}:
+				response = content
+			default:
+				panic("unexpected type")
+			}
+			if result.IsError {
+				response["error"] = true
+			}
+
+			nextMsg = append(nextMsg, genai.FunctionResponse{
+				Name:     call.name,
+				Response: response,
+			})
 		}
 
+		if finished {
+			g.response = strings.Join(text, "\n")
+			if needsConclusion(g.logger, g.response, concludeRetries) {
+				concludeRetries++
+				finished = false
+				nextMsg = append(nextMsg, genai.Text(concludePrompt))
+			}
+		}
 		if finished {
 			break
 		}
+		if err := checkMaxIterations(g.config, iterations); err != nil {
+			if len(calls) > 0 {
+				answerFunctionCalls(session, nextMsg)
+			}
+			return err
+		}
 
 		// Send next message with retries
 		retryCount = 0
+		turnStart = time.Now()
 		for retryCount <= maxRetries {
 			resp, err = session.SendMessage(ctx, nextMsg...)
 			if err == nil {
@@ -324,6 +485,7 @@ func (g *geminiExecutor) Execute(input string) error {
 			}
 			return fmt.Errorf("error sending message to Gemini: %w", err)
 		}
+		logTurnDuration(g.logger, turnStart)
 	}
 
 	return nil
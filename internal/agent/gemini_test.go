@@ -3,6 +3,7 @@ package agent
 import (
 	"testing"
 
+	"github.com/google/generative-ai-go/genai"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -74,3 +75,22 @@ func TestUnescapeString(t *testing.T) {
 		})
 	}
 }
+
+func TestStoppedResponsesAnswerEveryCall(t *testing.T) {
+	session := &genai.ChatSession{History: []*genai.Content{
+		genai.NewUserContent(genai.Text("list the files")),
+		{Role: "model", Parts: []genai.Part{
+			genai.Text("Listing the files"),
+			genai.FunctionCall{Name: "bash", Args: map[string]any{"command": "ls"}},
+			genai.FunctionCall{Name: "file_viewer", Args: map[string]any{"path": "main.go"}},
+		}},
+	}}
+
+	answerFunctionCalls(session, stoppedResponses(session.History[1].Parts, "stopping agent"))
+
+	assert.Len(t, session.History, 3)
+	assert.Equal(t, &genai.Content{Role: "user", Parts: []genai.Part{
+		genai.FunctionResponse{Name: "bash", Response: map[string]any{"result": "stopping agent", "error": true}},
+		genai.FunctionResponse{Name: "file_viewer", Response: map[string]any{"result": "stopping agent", "error": true}},
+	}}, session.History[2])
+}
@@ -0,0 +1,55 @@
+package agent
+
+import (
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// gitRunner runs git with the given arguments and returns its output
+type gitRunner func(args ...string) (string, error)
+
+// gitIn returns a gitRunner that runs the git executable in dir
+func gitIn(dir string) gitRunner {
+	return func(args ...string) (string, error) {
+		out, err := exec.Command("git", append([]string{"-C", dir}, args...)...).Output()
+		return string(out), err
+	}
+}
+
+// maxGitStatusLines caps the number of changed files listed in the git context
+const maxGitStatusLines = 30
+
+// gitContext summarizes the current branch, the uncommitted changes and the recent commits of the git repository
+// that run operates in. It returns an empty string outside of a git repository or when git is not installed
+func gitContext(run gitRunner) string {
+	if out, err := run("rev-parse", "--is-inside-work-tree"); err != nil || strings.TrimSpace(out) != "true" {
+		return ""
+	}
+
+	var sb strings.Builder
+	branch, err := run("branch", "--show-current")
+	if branch = strings.TrimSpace(branch); err != nil || branch == "" {
+		branch = "(detached HEAD)"
+	}
+	fmt.Fprintf(&sb, "Current branch: %s\n", branch)
+
+	status, _ := run("status", "--short")
+	if strings.TrimSpace(status) == "" {
+		sb.WriteString("Uncommitted changes: none\n")
+	} else {
+		lines := strings.Split(strings.TrimRight(status, "\n"), "\n")
+		sb.WriteString("Uncommitted changes (git status --short):\n")
+		for _, line := range lines[:min(len(lines), maxGitStatusLines)] {
+			sb.WriteString(line + "\n")
+		}
+		if len(lines) > maxGitStatusLines {
+			fmt.Fprintf(&sb, "... and %d more\n", len(lines)-maxGitStatusLines)
+		}
+	}
+
+	if commits, err := run("log", "--oneline", "-5"); err == nil && strings.TrimSpace(commits) != "" {
+		sb.WriteString("Recent commits:\n" + strings.TrimRight(commits, "\n") + "\n")
+	}
+	return strings.TrimRight(sb.String(), "\n")
+}
@@ -0,0 +1,67 @@
+package agent
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// stubGitRunner answers git commands from outputs, keyed by the space separated arguments
+func stubGitRunner(outputs map[string]string) gitRunner {
+	return func(args ...string) (string, error) {
+		out, ok := outputs[strings.Join(args, " ")]
+		if !ok {
+			return "", errors.New("exit status 128")
+		}
+		return out, nil
+	}
+}
+
+func TestGitContext(t *testing.T) {
+	repo := map[string]string{
+		"rev-parse --is-inside-work-tree": "true\n",
+		"branch --show-current":           "feature/plan-mode\n",
+		"status --short":                  " M main.go\n?? notes.txt\n",
+		"log --oneline -5":                "abc1234 Add plan mode\ndef5678 Fix typo\n",
+	}
+
+	summary := gitContext(stubGitRunner(repo))
+	assert.Equal(t, `Current branch: feature/plan-mode
+Uncommitted changes (git status --short):
+ M main.go
+?? notes.txt
+Recent commits:
+abc1234 Add plan mode
+def5678 Fix typo`, summary)
+
+	assert.Empty(t, gitContext(stubGitRunner(nil)), "outside of a repository")
+
+	clean := map[string]string{
+		"rev-parse --is-inside-work-tree": "true\n",
+		"branch --show-current":           "\n",
+		"status --short":                  "",
+		"log --oneline -5":                "",
+	}
+	assert.Equal(t, "Current branch: (detached HEAD)\nUncommitted changes: none", gitContext(stubGitRunner(clean)))
+
+	var status strings.Builder
+	for i := 0; i < maxGitStatusLines+5; i++ {
+		fmt.Fprintf(&status, " M file%d.go\n", i)
+	}
+	repo["status --short"] = status.String()
+	summary = gitContext(stubGitRunner(repo))
+	assert.Contains(t, summary, "... and 5 more")
+	assert.NotContains(t, summary, fmt.Sprintf("file%d.go", maxGitStatusLines))
+}
+
+func TestSystemPromptForRepoContext(t *testing.T) {
+	assert.Equal(t, systemPrompt, systemPromptFor(GenConfig{}))
+
+	prompt := systemPromptFor(GenConfig{RepoContext: "Current branch: main"})
+	assert.True(t, strings.HasPrefix(prompt, systemPrompt))
+	assert.Contains(t, prompt, "git repository in the current directory")
+	assert.Contains(t, prompt, "Current branch: main")
+}
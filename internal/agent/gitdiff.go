@@ -0,0 +1,70 @@
+package agent
+
+import (
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+var gitDiffTool = Tool{
+	Name: "git_diff",
+	Description: `A tool to get the diff of the uncommitted changes in the git repository of the current directory
+* By default, returns the staged and unstaged changes compared to the last commit (HEAD)
+* Set "ref" to compare against another commit, branch or tag, e.g. "main" to see every change made on the current branch
+* Set "paths" to only include changes to the given files or directories
+* Long diffs are truncated, narrow them down with "paths" to see the rest`,
+	InputSchema: map[string]interface{}{
+		"type": "object",
+		"properties": map[string]interface{}{
+			"ref": map[string]interface{}{
+				"type":        "string",
+				"description": `The commit, branch or tag to compare the working tree against (default "HEAD").`,
+			},
+			"paths": map[string]interface{}{
+				"type": "array",
+				"items": map[string]interface{}{
+					"type": "string",
+				},
+				"description": `Relative paths of files or directories to limit the diff to, e.g. ["./internal/agent"]`,
+			},
+		},
+	},
+}
+
+// maxGitDiffLength is the number of bytes of the diff returned to the model
+const maxGitDiffLength = 20000
+
+// executeGitDiffTool validates and executes the git diff tool, running git with run
+func executeGitDiffTool(run gitRunner, ref string, paths []string, maxLength int) (*ToolResult, error) {
+	if out, err := run("rev-parse", "--is-inside-work-tree"); err != nil || strings.TrimSpace(out) != "true" {
+		return &ToolResult{Content: "Error getting diff: the current directory is not inside a git repository", IsError: true}, nil
+	}
+	if ref == "" {
+		ref = "HEAD"
+	}
+	if strings.HasPrefix(ref, "-") {
+		return &ToolResult{Content: fmt.Sprintf("Error getting diff: invalid ref %q", ref), IsError: true}, nil
+	}
+
+	args := []string{"diff", ref}
+	if len(paths) > 0 {
+		args = append(append(args, "--"), paths...)
+	}
+	diff, err := run(args...)
+	if err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) && len(exitErr.Stderr) > 0 {
+			err = errors.New(strings.TrimSpace(string(exitErr.Stderr)))
+		}
+		return &ToolResult{Content: fmt.Sprintf("Error getting diff: %s", err), IsError: true}, nil
+	}
+
+	if strings.TrimSpace(diff) == "" {
+		return &ToolResult{Content: fmt.Sprintf("No changes compared to %s", ref)}, nil
+	}
+	if len(diff) > maxLength {
+		diff = fmt.Sprintf("%s\n... (diff truncated, %d more bytes, pass \"paths\" to see the rest)", diff[:maxLength], len(diff)-maxLength)
+	}
+	return &ToolResult{Content: diff}, nil
+}
@@ -0,0 +1,63 @@
+package agent
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExecuteGitDiffTool(t *testing.T) {
+	mainDiff := "diff --git a/main.go b/main.go\n-\tfmt.Println(\"hi\")\n+\tfmt.Println(\"hello\")\n"
+	agentDiff := "diff --git a/internal/agent/tools.go b/internal/agent/tools.go\n+// new comment\n"
+	repo := map[string]string{
+		"rev-parse --is-inside-work-tree":  "true\n",
+		"diff HEAD":                        mainDiff + agentDiff,
+		"diff HEAD -- main.go":             mainDiff,
+		"diff main -- internal/agent":      agentDiff,
+		"diff v1.0.0 -- docs/unchanged.md": "",
+	}
+
+	tests := []struct {
+		name     string
+		ref      string
+		paths    []string
+		expected string
+		isError  bool
+	}{
+		{name: "working tree", expected: mainDiff + agentDiff},
+		{name: "filtered by path", paths: []string{"main.go"}, expected: mainDiff},
+		{name: "against a ref", ref: "main", paths: []string{"internal/agent"}, expected: agentDiff},
+		{name: "no changes", ref: "v1.0.0", paths: []string{"docs/unchanged.md"}, expected: "No changes compared to v1.0.0"},
+		{name: "unknown ref", ref: "no-such-branch", expected: "Error getting diff: exit status 128", isError: true},
+		{name: "option as ref", ref: "--output=/tmp/x", expected: `Error getting diff: invalid ref "--output=/tmp/x"`, isError: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := executeGitDiffTool(stubGitRunner(repo), tt.ref, tt.paths, maxGitDiffLength)
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, result.Content)
+			assert.Equal(t, tt.isError, result.IsError)
+		})
+	}
+}
+
+func TestExecuteGitDiffToolOutsideRepository(t *testing.T) {
+	result, err := executeGitDiffTool(stubGitRunner(nil), "", nil, maxGitDiffLength)
+	require.NoError(t, err)
+	assert.True(t, result.IsError)
+	assert.Equal(t, "Error getting diff: the current directory is not inside a git repository", result.Content)
+}
+
+func TestExecuteGitDiffToolTruncates(t *testing.T) {
+	repo := map[string]string{
+		"rev-parse --is-inside-work-tree": "true\n",
+		"diff HEAD":                       strings.Repeat("+", 150),
+	}
+	result, err := executeGitDiffTool(stubGitRunner(repo), "", nil, 100)
+	require.NoError(t, err)
+	assert.False(t, result.IsError)
+	assert.Equal(t, strings.Repeat("+", 100)+"\n... (diff truncated, 50 more bytes, pass \"paths\" to see the rest)", result.Content)
+}
@@ -0,0 +1,33 @@
+package agent
+
+import (
+	"fmt"
+	"maps"
+	"net/http"
+	"slices"
+)
+
+// protectedHeaders are set by the SDKs for authentication and API versioning, and cannot be overridden by
+// the extra headers in GenConfig.Headers
+var protectedHeaders = []string{"Authorization", "X-Api-Key", "Api-Key", "Anthropic-Version", "Content-Type"}
+
+// validateHeaders returns an error if an extra header would override a header the SDKs depend on
+func validateHeaders(headers map[string]string) error {
+	for name := range headers {
+		if name == "" {
+			return fmt.Errorf("header name must not be empty")
+		}
+		if slices.Contains(protectedHeaders, http.CanonicalHeaderKey(name)) {
+			return fmt.Errorf("header %s cannot be overridden", name)
+		}
+	}
+	return nil
+}
+
+// forEachHeader calls add with every extra header, in order of name. The executors pass it their SDK's
+// WithHeaderAdd option, so that headers the SDK already sets, e.g. anthropic-beta, keep their values
+func forEachHeader(headers map[string]string, add func(name, value string)) {
+	for _, name := range slices.Sorted(maps.Keys(headers)) {
+		add(name, headers[name])
+	}
+}
@@ -0,0 +1,74 @@
+package agent
+
+import (
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	a "github.com/anthropics/anthropic-sdk-go"
+	gitignore "github.com/sabhiram/go-gitignore"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newHeaderRecordingServer returns a server that replies with response and records the headers of the last request
+func newHeaderRecordingServer(t *testing.T, response string) (*httptest.Server, *http.Header) {
+	t.Helper()
+	var headers http.Header
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		headers = r.Header.Clone()
+		w.Header().Set("Content-Type", "application/json")
+		io.WriteString(w, response)
+	}))
+	t.Cleanup(server.Close)
+	return server, &headers
+}
+
+func TestAnthropicExecutorSendsExtraHeaders(t *testing.T) {
+	server, headers := newHeaderRecordingServer(t, textResponse)
+
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	executor, err := NewAnthropicExecutor(server.URL, "test-key", nil, logger, gitignore.CompileIgnoreLines(), GenConfig{
+		Model:     a.ModelClaude3_5Sonnet20241022,
+		MaxTokens: 1024,
+		Headers: map[string]string{
+			"X-Org-Id":       "org-1",
+			"anthropic-beta": "prompt-caching-2024-07-31",
+		},
+	})
+	require.NoError(t, err)
+	require.NoError(t, executor.Execute("hello"))
+
+	assert.Equal(t, "org-1", headers.Get("X-Org-Id"))
+	assert.Equal(t, []string{"prompt-caching-2024-07-31"}, headers.Values("Anthropic-Beta"))
+	assert.Equal(t, "test-key", headers.Get("X-Api-Key"))
+	assert.NotEmpty(t, headers.Get("Anthropic-Version"))
+	assert.Equal(t, "application/json", headers.Get("Content-Type"))
+}
+
+func TestOpenAIExecutorSendsExtraHeaders(t *testing.T) {
+	server, headers := newHeaderRecordingServer(t, openaiContentResponse("done"))
+
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	executor, err := NewOpenAIExecutor(server.URL, "test-key", nil, logger, gitignore.CompileIgnoreLines(), GenConfig{
+		Model:   "gpt-4o",
+		Headers: map[string]string{"OpenAI-Organization": "org-1", "X-Route": "eu"},
+	})
+	require.NoError(t, err)
+	require.NoError(t, executor.Execute("hello"))
+
+	assert.Equal(t, "org-1", headers.Get("OpenAI-Organization"))
+	assert.Equal(t, "eu", headers.Get("X-Route"))
+	assert.Equal(t, "Bearer test-key", headers.Get("Authorization"))
+}
+
+func TestExecutorsRejectProtectedHeaders(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	_, err := NewOpenAIExecutor("http://localhost", "test-key", nil, logger, gitignore.CompileIgnoreLines(), GenConfig{
+		Model:   "gpt-4o",
+		Headers: map[string]string{"authorization": "Bearer other-key"},
+	})
+	assert.ErrorContains(t, err, "header authorization cannot be overridden")
+}
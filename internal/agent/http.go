@@ -0,0 +1,163 @@
+package agent
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// redactedHeaders are the request headers that carry credentials and are never written to the dump
+var redactedHeaders = []string{"Authorization", "X-Api-Key", "X-Goog-Api-Key"}
+
+// newHTTPClient returns the HTTP client executors use to talk to providers.
+// Requests are routed through the proxy named by the HTTPS_PROXY/HTTP_PROXY environment variables (honoring NO_PROXY),
+// see http.ProxyFromEnvironment. Self-hosted gateways with a private CA or mutual TLS are supported through
+// CPE_CA_BUNDLE, CPE_CLIENT_CERT and CPE_CLIENT_KEY (see newTLSConfig). When the CPE_DUMP_HTTP environment variable
+// is set to a file path, raw requests and responses are appended to that file, which helps diagnose
+// provider-specific issues.
+func newHTTPClient() (*http.Client, error) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = http.ProxyFromEnvironment
+
+	tlsConfig, err := newTLSConfig(os.Getenv("CPE_CA_BUNDLE"), os.Getenv("CPE_CLIENT_CERT"), os.Getenv("CPE_CLIENT_KEY"))
+	if err != nil {
+		return nil, err
+	}
+	if tlsConfig != nil {
+		transport.TLSClientConfig = tlsConfig
+	}
+
+	dumpPath := os.Getenv("CPE_DUMP_HTTP")
+	if dumpPath == "" {
+		return &http.Client{Transport: transport}, nil
+	}
+	f, err := os.OpenFile(dumpPath, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open http dump file %s: %w", dumpPath, err)
+	}
+	f.Close()
+	return &http.Client{Transport: &dumpTransport{next: transport, path: dumpPath}}, nil
+}
+
+// newTLSConfig builds the TLS configuration for provider requests, or returns nil to use the defaults when no
+// option is set. caBundlePath names a PEM file of CA certificates trusted in addition to the system roots.
+// clientCertPath and clientKeyPath name a PEM client certificate and key for mutual TLS, and must be set together.
+func newTLSConfig(caBundlePath, clientCertPath, clientKeyPath string) (*tls.Config, error) {
+	if caBundlePath == "" && clientCertPath == "" && clientKeyPath == "" {
+		return nil, nil
+	}
+
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+	if caBundlePath != "" {
+		pem, err := os.ReadFile(caBundlePath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CA bundle %s: %w", caBundlePath, err)
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("CA bundle %s contains no PEM encoded certificates", caBundlePath)
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	if (clientCertPath == "") != (clientKeyPath == "") {
+		return nil, fmt.Errorf("CPE_CLIENT_CERT and CPE_CLIENT_KEY must be set together")
+	}
+	if clientCertPath != "" {
+		cert, err := tls.LoadX509KeyPair(clientCertPath, clientKeyPath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load client certificate: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	return tlsConfig, nil
+}
+
+// normalizeBaseURL ensures a provider base URL ends with a trailing "/", so the SDKs join API paths onto it correctly
+func normalizeBaseURL(baseUrl string) string {
+	if baseUrl != "" && !strings.HasSuffix(baseUrl, "/") {
+		return baseUrl + "/"
+	}
+	return baseUrl
+}
+
+// dumpTransport is an http.RoundTripper that appends each request and response, with credentials redacted, to the
+// file at path. The file is opened for each exchange, so no handle is left open when the client is discarded
+type dumpTransport struct {
+	next http.RoundTripper
+	mu   sync.Mutex
+	path string
+}
+
+func (t *dumpTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	var reqBody []byte
+	if req.Body != nil {
+		var err error
+		reqBody, err = io.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read request body: %w", err)
+		}
+		req = req.Clone(req.Context())
+		req.Body = io.NopCloser(bytes.NewReader(reqBody))
+	}
+
+	resp, respErr := t.next.RoundTrip(req)
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	f, err := os.OpenFile(t.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		if resp != nil {
+			resp.Body.Close()
+		}
+		return nil, fmt.Errorf("failed to open http dump file %s: %w", t.path, err)
+	}
+	defer f.Close()
+
+	fmt.Fprintf(f, "--> %s %s\n", req.Method, req.URL)
+	writeHeaders(f, req.Header)
+	fmt.Fprintf(f, "\n%s\n\n", reqBody)
+	if respErr != nil {
+		fmt.Fprintf(f, "<-- error: %s\n\n", respErr)
+		return nil, respErr
+	}
+
+	respBody, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response body: %w", err)
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(respBody))
+
+	fmt.Fprintf(f, "<-- %s\n", resp.Status)
+	writeHeaders(f, resp.Header)
+	fmt.Fprintf(f, "\n%s\n\n", respBody)
+	return resp, nil
+}
+
+// writeHeaders writes header to w sorted by name, with the values of redactedHeaders replaced
+func writeHeaders(w io.Writer, header http.Header) {
+	keys := make([]string, 0, len(header))
+	for k := range header {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+	for _, k := range keys {
+		value := header.Get(k)
+		if slices.Contains(redactedHeaders, http.CanonicalHeaderKey(k)) {
+			value = "[REDACTED]"
+		}
+		fmt.Fprintf(w, "%s: %s\n", k, value)
+	}
+}
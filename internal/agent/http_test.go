@@ -0,0 +1,384 @@
+package agent
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"log/slog"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	a "github.com/anthropics/anthropic-sdk-go"
+	oai "github.com/openai/openai-go"
+	gitignore "github.com/sabhiram/go-gitignore"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const openaiTextResponse = `{
+	"id": "chatcmpl-1",
+	"object": "chat.completion",
+	"created": 1,
+	"model": "gpt-4o-2024-11-20",
+	"choices": [{"index": 0, "message": {"role": "assistant", "content": "all done"}, "finish_reason": "stop"}]
+}`
+
+// stubServer is a provider-neutral HTTP server that answers the n-th request with the n-th scripted response and
+// records the request bodies, so tests can inspect them once the executor returns
+type stubServer struct {
+	*httptest.Server
+	mu     sync.Mutex
+	bodies []string
+}
+
+// newStubServer starts a stubServer that replies with responses in order, and with an error once they run out
+func newStubServer(t *testing.T, responses ...string) *stubServer {
+	t.Helper()
+	s := &stubServer{}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		s.mu.Lock()
+		s.bodies = append(s.bodies, string(body))
+		n := len(s.bodies)
+		s.mu.Unlock()
+		if n > len(responses) {
+			http.Error(w, "unexpected request", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		io.WriteString(w, responses[n-1])
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+// requests returns the bodies of the requests received so far
+func (s *stubServer) requests() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return slices.Clone(s.bodies)
+}
+
+func TestNewHTTPClientWithoutDump(t *testing.T) {
+	t.Setenv("CPE_DUMP_HTTP", "")
+	client, err := newHTTPClient()
+	require.NoError(t, err)
+	assert.IsType(t, &http.Transport{}, client.Transport)
+}
+
+func TestNormalizeBaseURL(t *testing.T) {
+	assert.Equal(t, "", normalizeBaseURL(""))
+	assert.Equal(t, "https://gateway.example.com/v1/", normalizeBaseURL("https://gateway.example.com/v1"))
+	assert.Equal(t, "https://gateway.example.com/v1/", normalizeBaseURL("https://gateway.example.com/v1/"))
+}
+
+func TestInitExecutorCustomURL(t *testing.T) {
+	tests := []struct {
+		name     string
+		model    string
+		env      map[string]string
+		response string
+	}{
+		{
+			name:     "anthropic via CPE_CUSTOM_URL",
+			model:    "claude-3-5-sonnet",
+			env:      map[string]string{"ANTHROPIC_API_KEY": "test-key"},
+			response: textResponse,
+		},
+		{
+			name:     "openai via CPE_CUSTOM_URL",
+			model:    "gpt-4o",
+			env:      map[string]string{"OPENAI_API_KEY": "test-key"},
+			response: openaiTextResponse,
+		},
+		{
+			name:     "unknown model via CPE_CUSTOM_URL",
+			model:    "my-gateway-model",
+			env:      map[string]string{"OPENAI_API_KEY": "test-key"},
+			response: openaiTextResponse,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var requests int
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				requests++
+				w.Header().Set("Content-Type", "application/json")
+				io.WriteString(w, tt.response)
+			}))
+			defer server.Close()
+
+			t.Setenv("CPE_DUMP_HTTP", "")
+			// no trailing slash, to exercise normalization
+			t.Setenv("CPE_CUSTOM_URL", server.URL+"/v1")
+			for k, v := range tt.env {
+				t.Setenv(k, v)
+			}
+
+			executor, err := InitExecutor(slog.New(slog.NewTextHandler(io.Discard, nil)), ModelOptions{Model: tt.model})
+			require.NoError(t, err)
+			require.NoError(t, executor.Execute("hello"))
+			assert.Equal(t, 1, requests)
+		})
+	}
+}
+
+func TestNewHTTPClientProxy(t *testing.T) {
+	t.Setenv("CPE_DUMP_HTTP", "")
+	client, err := newHTTPClient()
+	require.NoError(t, err)
+
+	transport, ok := client.Transport.(*http.Transport)
+	require.True(t, ok)
+	require.NotNil(t, transport.Proxy)
+	assert.Equal(t, reflect.ValueOf(http.ProxyFromEnvironment).Pointer(), reflect.ValueOf(transport.Proxy).Pointer())
+}
+
+func TestHTTPDump(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	tests := []struct {
+		name         string
+		response     string
+		newExecutor  func(baseURL string, client *http.Client) (Executor, error)
+		expectedAuth string
+	}{
+		{
+			name:     "anthropic",
+			response: textResponse,
+			newExecutor: func(baseURL string, client *http.Client) (Executor, error) {
+				return NewAnthropicExecutor(baseURL, "sk-secret-key", client, logger, gitignore.CompileIgnoreLines(), GenConfig{
+					Model:     a.ModelClaude3_5Sonnet20241022,
+					MaxTokens: 1024,
+				})
+			},
+			expectedAuth: "X-Api-Key: [REDACTED]",
+		},
+		{
+			name:     "openai",
+			response: openaiTextResponse,
+			newExecutor: func(baseURL string, client *http.Client) (Executor, error) {
+				return NewOpenAIExecutor(baseURL, "sk-secret-key", client, logger, gitignore.CompileIgnoreLines(), GenConfig{
+					Model:     oai.ChatModelGPT4o2024_11_20,
+					MaxTokens: 1024,
+				})
+			},
+			expectedAuth: "Authorization: [REDACTED]",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "application/json")
+				io.WriteString(w, tt.response)
+			}))
+			defer server.Close()
+
+			dumpPath := filepath.Join(t.TempDir(), "http.log")
+			t.Setenv("CPE_DUMP_HTTP", dumpPath)
+			client, err := newHTTPClient()
+			require.NoError(t, err)
+			require.NotNil(t, client)
+
+			executor, err := tt.newExecutor(server.URL, client)
+			require.NoError(t, err)
+			require.NoError(t, executor.Execute("please dump this request"))
+
+			dump, err := os.ReadFile(dumpPath)
+			require.NoError(t, err)
+			assert.Contains(t, string(dump), "--> POST "+server.URL)
+			assert.Contains(t, string(dump), "please dump this request")
+			assert.Contains(t, string(dump), "<-- 200 OK")
+			assert.Contains(t, string(dump), tt.response)
+			assert.Contains(t, string(dump), tt.expectedAuth)
+			assert.NotContains(t, string(dump), "sk-secret-key")
+		})
+	}
+}
+
+func TestGeminiExecutorUsesHTTPClient(t *testing.T) {
+	var apiKeyHeader, query string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		apiKeyHeader = r.Header.Get("X-Goog-Api-Key")
+		query = r.URL.RawQuery
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusForbidden)
+		io.WriteString(w, `{"error": {"code": 403, "message": "denied by gateway", "status": "PERMISSION_DENIED"}}`)
+	}))
+	defer server.Close()
+
+	dumpPath := filepath.Join(t.TempDir(), "http.log")
+	t.Setenv("CPE_DUMP_HTTP", dumpPath)
+	client, err := newHTTPClient()
+	require.NoError(t, err)
+
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	executor, err := NewGeminiExecutor(server.URL, "sk-secret-key", client, logger, gitignore.CompileIgnoreLines(), GenConfig{
+		Model:     "gemini-1.5-pro-002",
+		MaxTokens: 1024,
+	})
+	require.NoError(t, err)
+	require.ErrorContains(t, executor.Execute("please dump this request"), "denied by gateway")
+
+	assert.Equal(t, "sk-secret-key", apiKeyHeader)
+	assert.NotContains(t, query, "sk-secret-key")
+	dump, err := os.ReadFile(dumpPath)
+	require.NoError(t, err)
+	assert.Contains(t, string(dump), "please dump this request")
+	assert.Contains(t, string(dump), "X-Goog-Api-Key: [REDACTED]")
+	assert.NotContains(t, string(dump), "sk-secret-key")
+}
+
+// writeSelfSignedCert generates a self-signed certificate and key, writes them as PEM files and returns their paths
+func writeSelfSignedCert(t *testing.T, dir, name string) (certPath, keyPath string, cert *x509.Certificate) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		IsCA:         true,
+
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+	cert, err = x509.ParseCertificate(der)
+	require.NoError(t, err)
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+
+	certPath = filepath.Join(dir, name+".crt")
+	keyPath = filepath.Join(dir, name+".key")
+	require.NoError(t, os.WriteFile(certPath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600))
+	require.NoError(t, os.WriteFile(keyPath, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600))
+	return certPath, keyPath, cert
+}
+
+func TestNewHTTPClientTLS(t *testing.T) {
+	dir := t.TempDir()
+	clientCert, clientKey, clientX509 := writeSelfSignedCert(t, dir, "client")
+
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "ok")
+	}))
+	clientCAs := x509.NewCertPool()
+	clientCAs.AddCert(clientX509)
+	server.TLS = &tls.Config{ClientAuth: tls.RequireAndVerifyClientCert, ClientCAs: clientCAs}
+	server.StartTLS()
+	defer server.Close()
+
+	caBundle := filepath.Join(dir, "gateway-ca.pem")
+	require.NoError(t, os.WriteFile(caBundle, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw}), 0600))
+
+	tests := []struct {
+		name       string
+		env        map[string]string
+		wantErr    string
+		requestErr bool
+	}{
+		{
+			name:       "defaults do not trust the private CA",
+			requestErr: true,
+		},
+		{
+			name:       "custom CA without client certificate is rejected by the gateway",
+			env:        map[string]string{"CPE_CA_BUNDLE": caBundle},
+			requestErr: true,
+		},
+		{
+			name: "custom CA with client certificate",
+			env:  map[string]string{"CPE_CA_BUNDLE": caBundle, "CPE_CLIENT_CERT": clientCert, "CPE_CLIENT_KEY": clientKey},
+		},
+		{
+			name:    "client certificate without key",
+			env:     map[string]string{"CPE_CA_BUNDLE": caBundle, "CPE_CLIENT_CERT": clientCert},
+			wantErr: "CPE_CLIENT_CERT and CPE_CLIENT_KEY must be set together",
+		},
+		{
+			name:    "CA bundle without certificates",
+			env:     map[string]string{"CPE_CA_BUNDLE": clientKey},
+			wantErr: "CA bundle " + clientKey + " contains no PEM encoded certificates",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, key := range []string{"CPE_CA_BUNDLE", "CPE_CLIENT_CERT", "CPE_CLIENT_KEY", "CPE_DUMP_HTTP"} {
+				t.Setenv(key, tt.env[key])
+			}
+
+			client, err := newHTTPClient()
+			if tt.wantErr != "" {
+				assert.EqualError(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+
+			resp, err := client.Get(server.URL)
+			if tt.requestErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			defer resp.Body.Close()
+			assert.Equal(t, http.StatusOK, resp.StatusCode)
+		})
+	}
+}
+
+func TestGeminiExecutorTLS(t *testing.T) {
+	dir := t.TempDir()
+	clientCert, clientKey, clientX509 := writeSelfSignedCert(t, dir, "client")
+
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusForbidden)
+		io.WriteString(w, `{"error": {"code": 403, "message": "reached the gateway", "status": "PERMISSION_DENIED"}}`)
+	}))
+	clientCAs := x509.NewCertPool()
+	clientCAs.AddCert(clientX509)
+	server.TLS = &tls.Config{ClientAuth: tls.RequireAndVerifyClientCert, ClientCAs: clientCAs}
+	server.StartTLS()
+	defer server.Close()
+
+	caBundle := filepath.Join(dir, "gateway-ca.pem")
+	require.NoError(t, os.WriteFile(caBundle, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw}), 0600))
+	t.Setenv("CPE_CA_BUNDLE", caBundle)
+	t.Setenv("CPE_CLIENT_CERT", clientCert)
+	t.Setenv("CPE_CLIENT_KEY", clientKey)
+	t.Setenv("CPE_DUMP_HTTP", "")
+
+	client, err := newHTTPClient()
+	require.NoError(t, err)
+
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	executor, err := NewGeminiExecutor(server.URL, "test-key", client, logger, gitignore.CompileIgnoreLines(), GenConfig{
+		Model:     "gemini-1.5-pro-002",
+		MaxTokens: 1024,
+	})
+	require.NoError(t, err)
+	assert.ErrorContains(t, executor.Execute("hello"), "reached the gateway")
+}
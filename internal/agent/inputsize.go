@@ -0,0 +1,43 @@
+package agent
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// checkInputSize returns an error if tokenizer counts more tokens in the request than config.MaxInputTokens, so
+// that an oversized request fails with guidance instead of being rejected by the provider. The request is made of
+// the system prompt, the conversation so far in history, counted as its JSON encoding, and the new input
+func checkInputSize(tokenizer Tokenizer, config GenConfig, system string, history any, input string) error {
+	if config.MaxInputTokens <= 0 {
+		return nil
+	}
+
+	request := system + "\n\n"
+	if history != nil {
+		encoded, err := json.Marshal(history)
+		if err != nil {
+			return fmt.Errorf("error encoding the conversation: %w", err)
+		}
+		if s := string(encoded); s != "null" && s != "[]" {
+			request += s + "\n\n"
+		}
+	}
+	request += input
+
+	// a token is at least one byte long, so short requests never need to be tokenized
+	if len(request) <= config.MaxInputTokens {
+		return nil
+	}
+	tokens, err := tokenizer.CountTokens(request)
+	if err != nil {
+		return err
+	}
+	if tokens <= config.MaxInputTokens {
+		return nil
+	}
+	return fmt.Errorf("the request, with the system prompt, the conversation so far and the input, is about %d tokens, "+
+		"more than the %d tokens model %s accepts. Attach fewer or smaller files with -files, trim the piped input, "+
+		"start a new conversation, raise the limit with -max-input-tokens or use a model with a larger context window, "+
+		"e.g. gemini-1-5-pro", tokens, config.MaxInputTokens, config.Model)
+}
@@ -0,0 +1,127 @@
+package agent
+
+import (
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	a "github.com/anthropics/anthropic-sdk-go"
+	gitignore "github.com/sabhiram/go-gitignore"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheckInputSize(t *testing.T) {
+	oversized := strings.Repeat("the quick brown fox jumps over the lazy dog ", 200)
+
+	tests := []struct {
+		name    string
+		config  GenConfig
+		system  string
+		history any
+		input   string
+		wantErr string
+	}{
+		{
+			name:   "input within the limit",
+			config: GenConfig{Model: "gpt-4o", MaxInputTokens: 100},
+			input:  "what does main.go do?",
+		},
+		{
+			name:   "long input with few tokens",
+			config: GenConfig{Model: "gpt-4o", MaxInputTokens: 100},
+			input:  strings.Repeat("a", 200),
+		},
+		{
+			name:    "oversized input",
+			config:  GenConfig{Model: "gpt-4o", MaxInputTokens: 100},
+			input:   oversized,
+			wantErr: "more than the 100 tokens model gpt-4o accepts",
+		},
+		{
+			name:    "long conversation with a short input",
+			config:  GenConfig{Model: "gpt-4o", MaxInputTokens: 100},
+			history: []map[string]string{{"role": "user", "content": oversized}, {"role": "assistant", "content": "done"}},
+			input:   "what does main.go do?",
+			wantErr: "more than the 100 tokens model gpt-4o accepts",
+		},
+		{
+			name:    "long system prompt with a short input",
+			config:  GenConfig{Model: "gpt-4o", MaxInputTokens: 100},
+			system:  oversized,
+			input:   "what does main.go do?",
+			wantErr: "more than the 100 tokens model gpt-4o accepts",
+		},
+		{
+			name:    "empty conversation",
+			config:  GenConfig{Model: "gpt-4o", MaxInputTokens: 100},
+			system:  "You are a coding agent.",
+			history: []map[string]string{},
+			input:   "what does main.go do?",
+		},
+		{
+			name:   "unknown context window skips the check",
+			config: GenConfig{Model: "my-gateway-model"},
+			input:  oversized,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkInputSize(tiktokenTokenizer{}, tt.config, tt.system, tt.history, tt.input)
+			if tt.wantErr == "" {
+				assert.NoError(t, err)
+				return
+			}
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tt.wantErr)
+			assert.Contains(t, err.Error(), "-files")
+			assert.Contains(t, err.Error(), "larger context window")
+		})
+	}
+}
+
+func TestGetConfigMaxInputTokens(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	config, err := GetConfig(logger, ModelOptions{Model: "gpt-4o"})
+	require.NoError(t, err)
+	assert.Equal(t, 128000-8192, config.MaxInputTokens)
+
+	config, err = GetConfig(logger, ModelOptions{Model: "gpt-4o", MaxInputTokens: 5000})
+	require.NoError(t, err)
+	assert.Equal(t, 5000, config.MaxInputTokens)
+
+	config, err = GetConfig(logger, ModelOptions{Model: "my-gateway-model", CustomURL: "http://localhost:8080"})
+	require.NoError(t, err)
+	assert.Zero(t, config.MaxInputTokens)
+}
+
+func TestAnthropicExecutorRejectsOversizedInput(t *testing.T) {
+	var paths []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		paths = append(paths, r.URL.Path)
+		w.Header().Set("Content-Type", "application/json")
+		if strings.HasSuffix(r.URL.Path, "/count_tokens") {
+			io.WriteString(w, `{"input_tokens": 60}`)
+			return
+		}
+		io.WriteString(w, textResponse)
+	}))
+	t.Cleanup(server.Close)
+
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	executor, err := NewAnthropicExecutor(server.URL, "test-key", nil, logger, gitignore.CompileIgnoreLines(), GenConfig{
+		Model:          a.ModelClaude3_5Sonnet20241022,
+		MaxTokens:      1024,
+		MaxInputTokens: 10,
+	})
+	require.NoError(t, err)
+
+	err = executor.Execute(strings.Repeat("explain this code ", 20))
+	assert.ErrorContains(t, err, "is about 60 tokens, more than the 10 tokens")
+	assert.Equal(t, []string{"/v1/messages/count_tokens"}, paths, "only the tokens are counted, no message is sent")
+}
@@ -1,10 +1,13 @@
 package agent
 
 import (
+	"encoding/json"
 	"fmt"
 	"github.com/anthropics/anthropic-sdk-go"
 	"github.com/openai/openai-go"
 	"log/slog"
+	"slices"
+	"strings"
 )
 
 // GenConfig represents the configuration when invoking a model.
@@ -13,15 +16,30 @@ import (
 type GenConfig struct {
 	Model             string
 	MaxTokens         int
-	Temperature       float32  // Controls randomness: 0.0 - 1.0
-	TopP              *float32 // Controls diversity: 0.0 - 1.0
-	TopK              *int     // Controls token sampling:
-	FrequencyPenalty  *float32 // Penalizes frequent tokens: -2.0 - 2.0
-	PresencePenalty   *float32 // Penalizes repeated tokens: -2.0 - 2.0
-	Stop              []string // List of sequences where the API will stop generating further tokens
-	NumberOfResponses *int     // Number of chat completion choices to generate
-	ToolChoice        string   // Controls tool use: "auto", "any", or "tool"
-	ForcedTool        string   // Name of the tool to force when ToolChoice is "tool"
+	Temperature       float32           // Controls randomness: 0.0 - 1.0
+	TopP              *float32          // Controls diversity: 0.0 - 1.0
+	TopK              *int              // Controls token sampling:
+	FrequencyPenalty  *float32          // Penalizes frequent tokens: -2.0 - 2.0
+	PresencePenalty   *float32          // Penalizes repeated tokens: -2.0 - 2.0
+	Stop              []string          // List of sequences where the API will stop generating further tokens
+	NumberOfResponses *int              // Number of chat completion choices to generate
+	ToolChoice        string            // Controls tool use: "auto", "any", or "tool"
+	ForcedTool        string            // Name of the tool to force when ToolChoice is "tool"
+	MaxIterations     int               // Maximum number of tool use turns before the agent loop is stopped, 0 or less for no limit
+	MaxToolRepeats    int               // Consecutive identical tool calls before the model is warned, one more stops the loop
+	ReadOnly          bool              // Only offer tools that cannot change the workspace, e.g. while planning. The response format does not apply
+	MaxParallelTools  int               // Maximum read-only tool calls from a single turn run concurrently, below 2 is sequential
+	FetchDomains      []string          // Domains the fetch_url tool may download from, the tool is only offered when set
+	ToolDescriptions  map[string]string // Tool description overrides by tool name, a leading "+" appends to the default
+	TestCommand       string            // Command the run_tests tool runs, the tool is only offered when set
+	ResponseFormat    string            // Format of the final response: "text" (the default), "json_object" or "json_schema"
+	ResponseSchema    map[string]any    // JSON schema the final response must follow when ResponseFormat is "json_schema"
+	MaxInputTokens    int               // Largest request, with the system prompt and conversation, in estimated tokens. 0 disables the check
+	RepoContext       string            // Summary of the git repository added to the system prompt, empty when disabled
+	ProjectContext    string            // Output of the user's context command added to the system prompt, empty when disabled
+	SpillToolOutput   int               // Tool results larger than this many bytes are written to a temporary file, 0 disables it
+	Headers           map[string]string // Extra HTTP headers sent with every request, they cannot override authentication headers
+	WorkDir           string            // Directory the tools operate in, empty for the current directory
 }
 
 type ModelDefaults struct {
@@ -38,6 +56,8 @@ type ModelConfig struct {
 	Name     string
 	IsKnown  bool
 	Defaults ModelDefaults
+	// ContextWindow is the number of tokens the model accepts, including the response. 0 means unknown
+	ContextWindow int
 }
 
 type ProviderConfig interface {
@@ -70,57 +90,63 @@ func (c OpenAIConfig) GetAPIKey() string {
 
 var ModelConfigs = map[string]ModelConfig{
 	"deepseek-chat": {
-		Name: "deepseek-chat", IsKnown: true,
+		Name: "deepseek-chat", IsKnown: true, ContextWindow: 64000,
 		Defaults: ModelDefaults{MaxTokens: 8192, Temperature: 0.3},
 	},
 	"claude-3-opus": {
-		Name: anthropic.ModelClaude_3_Opus_20240229, IsKnown: true,
+		Name: anthropic.ModelClaude_3_Opus_20240229, IsKnown: true, ContextWindow: 200000,
 		Defaults: ModelDefaults{MaxTokens: 4096, Temperature: 0.3},
 	},
 	"claude-3-5-sonnet": {
-		Name: anthropic.ModelClaude3_5Sonnet20241022, IsKnown: true,
+		Name: anthropic.ModelClaude3_5Sonnet20241022, IsKnown: true, ContextWindow: 200000,
 		Defaults: ModelDefaults{MaxTokens: 8192, Temperature: 0.3},
 	},
 	"claude-3-5-haiku": {
-		Name: anthropic.ModelClaude3_5Haiku20241022, IsKnown: true,
+		Name: anthropic.ModelClaude3_5Haiku20241022, IsKnown: true, ContextWindow: 200000,
 		Defaults: ModelDefaults{MaxTokens: 8192, Temperature: 0.3},
 	},
 	"claude-3-haiku": {
-		Name: anthropic.ModelClaude_3_Haiku_20240307, IsKnown: true,
+		Name: anthropic.ModelClaude_3_Haiku_20240307, IsKnown: true, ContextWindow: 200000,
 		Defaults: ModelDefaults{MaxTokens: 4096, Temperature: 0.3},
 	},
 	"gemini-1-5-flash-8b": {
-		Name: "gemini-1.5-flash-8b", IsKnown: true,
+		Name: "gemini-1.5-flash-8b", IsKnown: true, ContextWindow: 1048576,
 		Defaults: ModelDefaults{MaxTokens: 8192, Temperature: 0.3},
 	},
 	"gemini-1-5-flash": {
-		Name: "gemini-1.5-flash-002", IsKnown: true,
+		Name: "gemini-1.5-flash-002", IsKnown: true, ContextWindow: 1048576,
 		Defaults: ModelDefaults{MaxTokens: 8192, Temperature: 0.3},
 	},
 	"gemini-2-flash-exp": {
-		Name: "gemini-2.0-flash-exp", IsKnown: true,
+		Name: "gemini-2.0-flash-exp", IsKnown: true, ContextWindow: 1048576,
 		Defaults: ModelDefaults{MaxTokens: 8192, Temperature: 0.3},
 	},
 	"gemini-1-5-pro": {
-		Name: "gemini-1.5-pro-002", IsKnown: true,
+		Name: "gemini-1.5-pro-002", IsKnown: true, ContextWindow: 2097152,
 		Defaults: ModelDefaults{MaxTokens: 8192, Temperature: 0.3},
 	},
 	"gpt-4o": {
-		Name: openai.ChatModelGPT4o2024_11_20, IsKnown: true,
+		Name: openai.ChatModelGPT4o2024_11_20, IsKnown: true, ContextWindow: 128000,
 		Defaults: ModelDefaults{MaxTokens: 8192, Temperature: 0.3},
 	},
 	"gpt-4o-mini": {
-		Name: openai.ChatModelGPT4oMini2024_07_18, IsKnown: true,
+		Name: openai.ChatModelGPT4oMini2024_07_18, IsKnown: true, ContextWindow: 128000,
 		Defaults: ModelDefaults{MaxTokens: 8192, Temperature: 0.3},
 	},
 	"o1": {
-		Name: openai.ChatModelO1_2024_12_17, IsKnown: true,
+		Name: openai.ChatModelO1_2024_12_17, IsKnown: true, ContextWindow: 200000,
 		Defaults: ModelDefaults{MaxTokens: 100000, Temperature: 1},
 	},
 }
 
 var DefaultModel = "claude-3-5-sonnet"
 
+// DefaultMaxIterations is the default cap on tool use turns in a single execution
+var DefaultMaxIterations = 50
+
+// DefaultMaxToolRepeats is the default number of consecutive identical tool calls tolerated before the model is warned
+var DefaultMaxToolRepeats = 3
+
 type ModelOptions struct {
 	Model             string
 	CustomURL         string
@@ -131,6 +157,21 @@ type ModelOptions struct {
 	FrequencyPenalty  float64
 	PresencePenalty   float64
 	NumberOfResponses int
+	MaxIterations     int
+	MaxToolRepeats    int
+	ReadOnly          bool
+	MaxParallelTools  int
+	FetchDomains      []string
+	ToolDescriptions  map[string]string
+	TestCommand       string
+	ResponseFormat    string
+	ResponseSchema    map[string]any
+	MaxInputTokens    int
+	GitContext        bool
+	ContextCommand    string
+	SpillToolOutput   int
+	Headers           map[string]string
+	WorkDir           string
 	Input             string
 	Version           bool
 }
@@ -162,6 +203,50 @@ func (f ModelOptions) ApplyToGenConfig(config GenConfig) GenConfig {
 		numResponses := f.NumberOfResponses
 		config.NumberOfResponses = &numResponses
 	}
+	if f.MaxIterations != 0 {
+		config.MaxIterations = f.MaxIterations
+	}
+	if f.MaxToolRepeats != 0 {
+		config.MaxToolRepeats = f.MaxToolRepeats
+	}
+	config.ReadOnly = f.ReadOnly
+	if f.MaxParallelTools != 0 {
+		config.MaxParallelTools = f.MaxParallelTools
+	}
+	if len(f.FetchDomains) > 0 {
+		config.FetchDomains = f.FetchDomains
+	}
+	if len(f.ToolDescriptions) > 0 {
+		config.ToolDescriptions = f.ToolDescriptions
+	}
+	if f.TestCommand != "" {
+		config.TestCommand = f.TestCommand
+	}
+	if f.SpillToolOutput != 0 {
+		config.SpillToolOutput = f.SpillToolOutput
+	}
+	if len(f.Headers) > 0 {
+		config.Headers = f.Headers
+	}
+	if f.WorkDir != "" {
+		config.WorkDir = f.WorkDir
+	}
+	if f.MaxInputTokens != 0 {
+		config.MaxInputTokens = f.MaxInputTokens
+	}
+	if f.ResponseFormat != "" {
+		config.ResponseFormat = f.ResponseFormat
+	}
+	if f.ResponseSchema != nil {
+		config.ResponseSchema = f.ResponseSchema
+		if config.ResponseFormat == "" {
+			config.ResponseFormat = ResponseFormatJSONSchema
+		}
+	}
+	if config.ReadOnly {
+		// the final response of a read-only executor is a plan for review, the response format applies to its execution
+		config.ResponseFormat, config.ResponseSchema = "", nil
+	}
 	return config
 }
 
@@ -181,9 +266,11 @@ func GetConfig(logger *slog.Logger, flags ModelOptions) (GenConfig, error) {
 	}
 
 	genConfig := GenConfig{
-		Model:       config.Name,
-		MaxTokens:   config.Defaults.MaxTokens,
-		Temperature: config.Defaults.Temperature,
+		Model:          config.Name,
+		MaxTokens:      config.Defaults.MaxTokens,
+		Temperature:    config.Defaults.Temperature,
+		MaxIterations:  DefaultMaxIterations,
+		MaxToolRepeats: DefaultMaxToolRepeats,
 	}
 
 	if config.Defaults.TopP != nil {
@@ -203,6 +290,120 @@ func GetConfig(logger *slog.Logger, flags ModelOptions) (GenConfig, error) {
 	}
 
 	genConfig = flags.ApplyToGenConfig(genConfig)
+	if genConfig.MaxInputTokens == 0 && config.ContextWindow > genConfig.MaxTokens {
+		// leave room in the context window for the response
+		genConfig.MaxInputTokens = config.ContextWindow - genConfig.MaxTokens
+	}
 
 	return genConfig, nil
 }
+
+// providerLimits describes the generation parameters a provider's API accepts
+type providerLimits struct {
+	name              string
+	maxTemperature    float32
+	requiresMaxTokens bool
+	supportsTopK      bool
+	supportsPenalties bool
+	// responseFormats lists the structured response formats the provider can enforce, besides plain text
+	responseFormats []string
+	// supportsHeaders is set if extra HTTP headers can be sent with the provider's requests
+	supportsHeaders bool
+}
+
+// Response formats of the final response of the model
+const (
+	ResponseFormatText       = "text"
+	ResponseFormatJSONObject = "json_object"
+	ResponseFormatJSONSchema = "json_schema"
+)
+
+var (
+	anthropicLimits = providerLimits{name: "anthropic", maxTemperature: 1, requiresMaxTokens: true, supportsTopK: true, supportsHeaders: true}
+	geminiLimits    = providerLimits{name: "gemini", maxTemperature: 2, supportsTopK: true}
+	openaiLimits    = providerLimits{name: "openai", maxTemperature: 2, supportsPenalties: true, responseFormats: []string{ResponseFormatJSONObject, ResponseFormatJSONSchema}, supportsHeaders: true}
+	deepseekLimits  = providerLimits{name: "deepseek", maxTemperature: 2, supportsPenalties: true, responseFormats: []string{ResponseFormatJSONObject}, supportsHeaders: true}
+)
+
+// validateGenConfig checks the generation config against the constraints of the provider that will serve it,
+// so that invalid combinations fail before any request is sent rather than being rejected (or silently ignored) by the API
+func validateGenConfig(limits providerLimits, config GenConfig) error {
+	if config.MaxTokens < 0 || (limits.requiresMaxTokens && config.MaxTokens == 0) {
+		return fmt.Errorf("max tokens must be greater than 0, got %d", config.MaxTokens)
+	}
+	if config.Temperature < 0 || config.Temperature > limits.maxTemperature {
+		return fmt.Errorf("temperature must be between 0 and %g for %s models, got %g", limits.maxTemperature, limits.name, config.Temperature)
+	}
+	if strings.HasPrefix(config.Model, "o1") && config.Temperature != 1 {
+		return fmt.Errorf("model %s only supports a temperature of 1, got %g", config.Model, config.Temperature)
+	}
+	if config.TopP != nil && (*config.TopP < 0 || *config.TopP > 1) {
+		return fmt.Errorf("top-p must be between 0 and 1, got %g", *config.TopP)
+	}
+	if config.TopK != nil {
+		if !limits.supportsTopK {
+			return fmt.Errorf("top-k is not supported by %s models", limits.name)
+		}
+		if *config.TopK <= 0 {
+			return fmt.Errorf("top-k must be greater than 0, got %d", *config.TopK)
+		}
+	}
+	for _, p := range []struct {
+		name  string
+		value *float32
+	}{
+		{"frequency penalty", config.FrequencyPenalty},
+		{"presence penalty", config.PresencePenalty},
+	} {
+		if p.value == nil {
+			continue
+		}
+		if !limits.supportsPenalties {
+			return fmt.Errorf("%s is not supported by %s models", p.name, limits.name)
+		}
+		if *p.value < -2 || *p.value > 2 {
+			return fmt.Errorf("%s must be between -2 and 2, got %g", p.name, *p.value)
+		}
+	}
+	switch config.ResponseFormat {
+	case "", ResponseFormatText:
+	case ResponseFormatJSONObject, ResponseFormatJSONSchema:
+		if !slices.Contains(limits.responseFormats, config.ResponseFormat) {
+			return fmt.Errorf("response format %s is not supported by %s models", config.ResponseFormat, limits.name)
+		}
+		if config.ResponseFormat == ResponseFormatJSONSchema && config.ResponseSchema == nil {
+			return fmt.Errorf("response format %s requires a response schema", config.ResponseFormat)
+		}
+	default:
+		return fmt.Errorf("unknown response format %q, expected %s, %s or %s", config.ResponseFormat, ResponseFormatText, ResponseFormatJSONObject, ResponseFormatJSONSchema)
+	}
+	if len(config.Headers) > 0 {
+		if !limits.supportsHeaders {
+			return fmt.Errorf("extra headers are not supported by %s models", limits.name)
+		}
+		if err := validateHeaders(config.Headers); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// jsonResponseRequested reports whether the final response of the model must be JSON
+func jsonResponseRequested(config GenConfig) bool {
+	return config.ResponseFormat == ResponseFormatJSONObject || config.ResponseFormat == ResponseFormatJSONSchema
+}
+
+// checkJSONResponse returns an error if the config requests a JSON response and the final response is not valid JSON
+func checkJSONResponse(config GenConfig, response string) error {
+	if !jsonResponseRequested(config) {
+		return nil
+	}
+	if response == "" {
+		return fmt.Errorf("the model did not produce a final response, expected %s", config.ResponseFormat)
+	}
+	var v any
+	if err := json.Unmarshal([]byte(response), &v); err != nil {
+		return fmt.Errorf("the final response is not valid JSON: %w", err)
+	}
+	return nil
+}
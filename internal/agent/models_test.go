@@ -0,0 +1,192 @@
+package agent
+
+import (
+	"io"
+	"log/slog"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidateGenConfig(t *testing.T) {
+	ptr := func(f float32) *float32 { return &f }
+	intPtr := func(i int) *int { return &i }
+
+	tests := []struct {
+		name    string
+		limits  providerLimits
+		config  GenConfig
+		wantErr string
+	}{
+		{
+			name:   "valid anthropic config",
+			limits: anthropicLimits,
+			config: GenConfig{Model: "claude-3-5-sonnet-20241022", MaxTokens: 8192, Temperature: 0.3, TopP: ptr(0.9), TopK: intPtr(40)},
+		},
+		{
+			name:   "valid openai config with penalties",
+			limits: openaiLimits,
+			config: GenConfig{Model: "gpt-4o", MaxTokens: 8192, Temperature: 1.5, FrequencyPenalty: ptr(0.5), PresencePenalty: ptr(-0.5)},
+		},
+		{
+			name:   "valid o1 config",
+			limits: openaiLimits,
+			config: GenConfig{Model: "o1-2024-12-17", MaxTokens: 100000, Temperature: 1},
+		},
+		{
+			name:   "unset max tokens falls back to the openai default",
+			limits: openaiLimits,
+			config: GenConfig{Model: "my-gateway-model", Temperature: 0.3},
+		},
+		{
+			name:    "negative max tokens",
+			limits:  openaiLimits,
+			config:  GenConfig{Model: "gpt-4o", MaxTokens: -1},
+			wantErr: "max tokens must be greater than 0, got -1",
+		},
+		{
+			name:    "missing max tokens",
+			limits:  anthropicLimits,
+			config:  GenConfig{Model: "claude-3-5-sonnet-20241022", Temperature: 0.3},
+			wantErr: "max tokens must be greater than 0, got 0",
+		},
+		{
+			name:    "anthropic temperature above 1",
+			limits:  anthropicLimits,
+			config:  GenConfig{Model: "claude-3-5-sonnet-20241022", MaxTokens: 8192, Temperature: 1.5},
+			wantErr: "temperature must be between 0 and 1 for anthropic models, got 1.5",
+		},
+		{
+			name:    "negative temperature",
+			limits:  geminiLimits,
+			config:  GenConfig{Model: "gemini-1.5-pro-002", MaxTokens: 8192, Temperature: -0.1},
+			wantErr: "temperature must be between 0 and 2 for gemini models, got -0.1",
+		},
+		{
+			name:    "o1 with non-default temperature",
+			limits:  openaiLimits,
+			config:  GenConfig{Model: "o1-2024-12-17", MaxTokens: 100000, Temperature: 0.3},
+			wantErr: "model o1-2024-12-17 only supports a temperature of 1, got 0.3",
+		},
+		{
+			name:    "top-p out of range",
+			limits:  anthropicLimits,
+			config:  GenConfig{Model: "claude-3-5-sonnet-20241022", MaxTokens: 8192, TopP: ptr(1.2)},
+			wantErr: "top-p must be between 0 and 1, got 1.2",
+		},
+		{
+			name:    "top-k unsupported by openai",
+			limits:  openaiLimits,
+			config:  GenConfig{Model: "gpt-4o", MaxTokens: 8192, TopK: intPtr(40)},
+			wantErr: "top-k is not supported by openai models",
+		},
+		{
+			name:    "non-positive top-k",
+			limits:  geminiLimits,
+			config:  GenConfig{Model: "gemini-1.5-pro-002", MaxTokens: 8192, TopK: intPtr(0)},
+			wantErr: "top-k must be greater than 0, got 0",
+		},
+		{
+			name:    "penalties unsupported by anthropic",
+			limits:  anthropicLimits,
+			config:  GenConfig{Model: "claude-3-5-sonnet-20241022", MaxTokens: 8192, PresencePenalty: ptr(0.5)},
+			wantErr: "presence penalty is not supported by anthropic models",
+		},
+		{
+			name:    "frequency penalty out of range",
+			limits:  deepseekLimits,
+			config:  GenConfig{Model: "deepseek-chat", MaxTokens: 8192, FrequencyPenalty: ptr(2.5)},
+			wantErr: "frequency penalty must be between -2 and 2, got 2.5",
+		},
+		{
+			name:   "json schema response format with openai",
+			limits: openaiLimits,
+			config: GenConfig{Model: "gpt-4o", MaxTokens: 8192, ResponseFormat: ResponseFormatJSONSchema, ResponseSchema: map[string]any{"type": "object"}},
+		},
+		{
+			name:   "json object response format with deepseek",
+			limits: deepseekLimits,
+			config: GenConfig{Model: "deepseek-chat", MaxTokens: 8192, ResponseFormat: ResponseFormatJSONObject},
+		},
+		{
+			name:    "json response format unsupported by anthropic",
+			limits:  anthropicLimits,
+			config:  GenConfig{Model: "claude-3-5-sonnet-20241022", MaxTokens: 8192, ResponseFormat: ResponseFormatJSONObject},
+			wantErr: "response format json_object is not supported by anthropic models",
+		},
+		{
+			name:    "json schema response format unsupported by deepseek",
+			limits:  deepseekLimits,
+			config:  GenConfig{Model: "deepseek-chat", MaxTokens: 8192, ResponseFormat: ResponseFormatJSONSchema, ResponseSchema: map[string]any{"type": "object"}},
+			wantErr: "response format json_schema is not supported by deepseek models",
+		},
+		{
+			name:    "json schema response format without a schema",
+			limits:  openaiLimits,
+			config:  GenConfig{Model: "gpt-4o", MaxTokens: 8192, ResponseFormat: ResponseFormatJSONSchema},
+			wantErr: "response format json_schema requires a response schema",
+		},
+		{
+			name:    "unknown response format",
+			limits:  openaiLimits,
+			config:  GenConfig{Model: "gpt-4o", MaxTokens: 8192, ResponseFormat: "xml"},
+			wantErr: `unknown response format "xml", expected text, json_object or json_schema`,
+		},
+		{
+			name:   "extra headers",
+			limits: openaiLimits,
+			config: GenConfig{Model: "gpt-4o", Headers: map[string]string{"OpenAI-Organization": "org-1"}},
+		},
+		{
+			name:    "extra headers overriding authentication",
+			limits:  anthropicLimits,
+			config:  GenConfig{Model: "claude-3-5-sonnet-20241022", MaxTokens: 8192, Headers: map[string]string{"x-api-key": "other"}},
+			wantErr: "header x-api-key cannot be overridden",
+		},
+		{
+			name:    "extra headers with gemini",
+			limits:  geminiLimits,
+			config:  GenConfig{Model: "gemini-1.5-pro", Headers: map[string]string{"X-Route": "eu"}},
+			wantErr: "extra headers are not supported by gemini models",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateGenConfig(tt.limits, tt.config)
+			if tt.wantErr == "" {
+				assert.NoError(t, err)
+				return
+			}
+			assert.EqualError(t, err, tt.wantErr)
+		})
+	}
+}
+
+func TestGetConfigMaxIterations(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	config, err := GetConfig(logger, ModelOptions{Model: "gpt-4o"})
+	require.NoError(t, err)
+	assert.Equal(t, DefaultMaxIterations, config.MaxIterations)
+
+	config, err = GetConfig(logger, ModelOptions{Model: "gpt-4o", MaxIterations: -1})
+	require.NoError(t, err)
+	assert.Equal(t, -1, config.MaxIterations)
+}
+
+func TestGetConfigReadOnlyDropsResponseFormat(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	schema := map[string]any{"type": "object"}
+
+	config, err := GetConfig(logger, ModelOptions{Model: "gpt-4o", ResponseSchema: schema})
+	require.NoError(t, err)
+	assert.Equal(t, ResponseFormatJSONSchema, config.ResponseFormat)
+	assert.Equal(t, schema, config.ResponseSchema)
+
+	config, err = GetConfig(logger, ModelOptions{Model: "gpt-4o", ResponseSchema: schema, ReadOnly: true})
+	require.NoError(t, err)
+	assert.Empty(t, config.ResponseFormat)
+	assert.Nil(t, config.ResponseSchema)
+}
@@ -8,7 +8,8 @@ import (
 	"github.com/openai/openai-go/option"
 	gitignore "github.com/sabhiram/go-gitignore"
 	"log/slog"
-	"strings"
+	"net/http"
+	"slices"
 	"time"
 )
 
@@ -17,35 +18,78 @@ type openaiExecutor struct {
 	logger  *slog.Logger
 	ignorer *gitignore.GitIgnore
 	config  GenConfig
+	// tokenizer counts the tokens of the input before it is sent
+	tokenizer Tokenizer
+	// messages holds the conversation so far, including the system prompt,
+	// so that subsequent calls to Execute continue it
+	messages []oai.ChatCompletionMessageParamUnion
+	// response is the text of the model's final response to the last input
+	response string
 }
 
-func NewOpenAIExecutor(baseUrl string, apiKey string, logger *slog.Logger, ignorer *gitignore.GitIgnore, config GenConfig) Executor {
+func NewOpenAIExecutor(baseUrl string, apiKey string, httpClient *http.Client, logger *slog.Logger, ignorer *gitignore.GitIgnore, config GenConfig) (Executor, error) {
+	if err := validateGenConfig(openaiLimits, config); err != nil {
+		return nil, fmt.Errorf("invalid generation config: %w", err)
+	}
+
 	opts := []option.RequestOption{
 		option.WithAPIKey(apiKey),
 		option.WithMaxRetries(5),
 		option.WithRequestTimeout(5 * time.Minute),
 	}
+	forEachHeader(config.Headers, func(name, value string) {
+		opts = append(opts, option.WithHeaderAdd(name, value))
+	})
+	if httpClient != nil {
+		opts = append(opts, option.WithHTTPClient(httpClient))
+	}
 	if baseUrl != "" {
-		// Ensure baseURL ends with a trailing "/"
-		if !strings.HasSuffix(baseUrl, "/") {
-			baseUrl = baseUrl + "/"
-		}
-		opts = append(opts, option.WithBaseURL(baseUrl))
+		opts = append(opts, option.WithBaseURL(normalizeBaseURL(baseUrl)))
 	}
 	client := oai.NewClient(opts...)
 	return &openaiExecutor{
-		client:  client,
-		logger:  logger,
-		ignorer: ignorer,
-		config:  config,
+		client:    client,
+		logger:    logger,
+		ignorer:   ignorer,
+		config:    config,
+		tokenizer: selectTokenizer(config.Model, nil, logger),
+	}, nil
+}
+
+// openaiResponseFormat returns the response format parameter for the configured response format,
+// or nil for plain text
+func openaiResponseFormat(config GenConfig) oai.ChatCompletionNewParamsResponseFormatUnion {
+	switch config.ResponseFormat {
+	case ResponseFormatJSONObject:
+		return oai.ResponseFormatJSONObjectParam{
+			Type: oai.F(oai.ResponseFormatJSONObjectTypeJSONObject),
+		}
+	case ResponseFormatJSONSchema:
+		return oai.ResponseFormatJSONSchemaParam{
+			Type: oai.F(oai.ResponseFormatJSONSchemaTypeJSONSchema),
+			JSONSchema: oai.F(oai.ResponseFormatJSONSchemaJSONSchemaParam{
+				Name:   oai.F("response"),
+				Schema: oai.F[any](config.ResponseSchema),
+				Strict: oai.F(true),
+			}),
+		}
+	default:
+		return nil
 	}
 }
 
 func (o *openaiExecutor) Execute(input string) error {
+	// the kept messages start with the system prompt
+	history := o.messages
+	if len(history) > 0 {
+		history = history[1:]
+	}
+	if err := checkInputSize(o.tokenizer, o.config, systemPromptFor(o.config), history, input); err != nil {
+		return err
+	}
 	params := oai.ChatCompletionNewParams{
-		Model:               oai.F(o.config.Model),
-		MaxCompletionTokens: oai.Int(int64(o.config.MaxTokens)),
-		Temperature:         oai.Float(float64(o.config.Temperature)),
+		Model:       oai.F(o.config.Model),
+		Temperature: oai.Float(float64(o.config.Temperature)),
 		Tools: oai.F([]oai.ChatCompletionToolParam{
 			{
 				Type: oai.F(oai.ChatCompletionToolTypeFunction),
@@ -79,28 +123,97 @@ func (o *openaiExecutor) Execute(input string) error {
 					Parameters:  oai.F(oai.FunctionParameters(getRelatedFilesTool.InputSchema)),
 				}),
 			},
+			{
+				Type: oai.F(oai.ChatCompletionToolTypeFunction),
+				Function: oai.F(oai.FunctionDefinitionParam{
+					Name:        oai.F(applyPatchTool.Name),
+					Description: oai.F(applyPatchTool.Description),
+					Parameters:  oai.F(oai.FunctionParameters(applyPatchTool.InputSchema)),
+				}),
+			},
+			{
+				Type: oai.F(oai.ChatCompletionToolTypeFunction),
+				Function: oai.F(oai.FunctionDefinitionParam{
+					Name:        oai.F(fetchURLTool.Name),
+					Description: oai.F(fetchURLTool.Description),
+					Parameters:  oai.F(oai.FunctionParameters(fetchURLTool.InputSchema)),
+				}),
+			},
+			{
+				Type: oai.F(oai.ChatCompletionToolTypeFunction),
+				Function: oai.F(oai.FunctionDefinitionParam{
+					Name:        oai.F(runTestsTool.Name),
+					Description: oai.F(runTestsTool.Description),
+					Parameters:  oai.F(oai.FunctionParameters(runTestsTool.InputSchema)),
+				}),
+			},
+			{
+				Type: oai.F(oai.ChatCompletionToolTypeFunction),
+				Function: oai.F(oai.FunctionDefinitionParam{
+					Name:        oai.F(readSymbolTool.Name),
+					Description: oai.F(readSymbolTool.Description),
+					Parameters:  oai.F(oai.FunctionParameters(readSymbolTool.InputSchema)),
+				}),
+			},
+			{
+				Type: oai.F(oai.ChatCompletionToolTypeFunction),
+				Function: oai.F(oai.FunctionDefinitionParam{
+					Name:        oai.F(gitDiffTool.Name),
+					Description: oai.F(gitDiffTool.Description),
+					Parameters:  oai.F(oai.FunctionParameters(gitDiffTool.InputSchema)),
+				}),
+			},
 		}),
 	}
 
+	params.Tools = oai.F(slices.DeleteFunc(params.Tools.Value, func(tool oai.ChatCompletionToolParam) bool {
+		return !toolEnabled(o.config, tool.Function.Value.Name.Value)
+	}))
+	for i, tool := range params.Tools.Value {
+		function := tool.Function.Value
+		function.Description = oai.F(toolDescription(o.config, function.Name.Value, function.Description.Value))
+		params.Tools.Value[i].Function = oai.F(function)
+	}
+	if o.config.MaxTokens > 0 {
+		params.MaxCompletionTokens = oai.Int(int64(o.config.MaxTokens))
+	}
 	if o.config.TopP != nil {
 		params.TopP = oai.Float(float64(*o.config.TopP))
 	}
 	if o.config.Stop != nil {
 		params.Stop = oai.F[oai.ChatCompletionNewParamsStopUnion](oai.ChatCompletionNewParamsStopArray(o.config.Stop))
 	}
+	if responseFormat := openaiResponseFormat(o.config); responseFormat != nil {
+		params.ResponseFormat = oai.F(responseFormat)
+	}
 
 	// Add system prompt and user input as messages
-	params.Messages = oai.F([]oai.ChatCompletionMessageParamUnion{
-		oai.SystemMessage(agentInstructions),
-		oai.UserMessage(input),
-	})
+	if len(o.messages) == 0 {
+		o.messages = []oai.ChatCompletionMessageParamUnion{oai.SystemMessage(systemPromptFor(o.config))}
+	}
+	params.Messages = oai.F(append(o.messages, oai.UserMessage(input)))
+
+	// Keep the conversation so the next call to Execute continues it
+	defer func() {
+		o.messages = params.Messages.Value
+	}()
+
+	o.response = ""
+	tracker := newToolCallTracker(o.config.MaxToolRepeats)
+	cache := newToolCache()
+	concludeRetries := 0
+	for iterations := 0; ; iterations++ {
+		if err := checkMaxIterations(o.config, iterations); err != nil {
+			return err
+		}
 
-	for {
 		// Create message
+		turnStart := time.Now()
 		resp, err := o.client.Chat.Completions.New(context.Background(), params)
 		if err != nil {
 			return fmt.Errorf("failed to create message: %w", err)
 		}
+		logTurnDuration(o.logger, turnStart)
 
 		if len(resp.Choices) == 0 {
 			return fmt.Errorf("no response generated")
@@ -118,75 +231,66 @@ func (o *openaiExecutor) Execute(input string) error {
 
 		// If no tool calls, add message and finish
 		if len(choice.Message.ToolCalls) == 0 {
+			o.response = choice.Message.Content
 			params.Messages = oai.F(append(params.Messages.Value, assistantMsg...))
+			if needsConclusion(o.logger, o.response, concludeRetries) {
+				concludeRetries++
+				params.Messages = oai.F(append(params.Messages.Value, oai.UserMessage(concludePrompt)))
+				continue
+			}
 			break
 		}
 
 		// Process tool calls
+		calls := make([]toolInvocation, 0, len(choice.Message.ToolCalls))
 		for _, toolCall := range choice.Message.ToolCalls {
 			o.logger.Info(fmt.Sprintf("Tool: %s", toolCall.Function.Name))
 
-			var result *ToolResult
-
-			switch toolCall.Function.Name {
-			case bashTool.Name:
-				var bashToolInput struct {
-					Command string `json:"command"`
-				}
-				if err := json.Unmarshal([]byte(toolCall.Function.Arguments), &bashToolInput); err != nil {
-					return fmt.Errorf("failed to unmarshal bash tool arguments: %w", err)
-				}
-				o.logger.Info(fmt.Sprintf("executing bash command: %s", bashToolInput.Command))
-				result, err = executeBashTool(bashToolInput.Command)
-			case fileEditor.Name:
-				var fileEditorToolInput FileEditorParams
-				if err := json.Unmarshal([]byte(toolCall.Function.Arguments), &fileEditorToolInput); err != nil {
-					return fmt.Errorf("failed to unmarshal file editor tool arguments: %w", err)
-				}
-				o.logger.Info("executing file editor tool",
-					slog.String("command", fileEditorToolInput.Command),
-					slog.String("path", fileEditorToolInput.Path),
-				)
-				o.logger.Info(fmt.Sprintf("old_str:\n%s\n\nnew_str:\n%s", fileEditorToolInput.OldStr, fileEditorToolInput.NewStr))
-				result, err = executeFileEditorTool(fileEditorToolInput)
-			case filesOverviewTool.Name:
-				o.logger.Info("executing files overview tool")
-				result, err = executeFilesOverviewTool(o.ignorer)
-			case getRelatedFilesTool.Name:
-				var relatedFilesToolInput struct {
-					InputFiles []string `json:"input_files"`
-				}
-				if err := json.Unmarshal([]byte(toolCall.Function.Arguments), &relatedFilesToolInput); err != nil {
-					return fmt.Errorf("failed to unmarshal get related files tool arguments: %w", err)
-				}
-				o.logger.Info("getting related files", slog.Any("input_files", relatedFilesToolInput.InputFiles))
-				result, err = executeGetRelatedFilesTool(relatedFilesToolInput.InputFiles, o.ignorer)
-			default:
-				return fmt.Errorf("unexpected tool name: %s", toolCall.Function.Name)
+			repeats := tracker.track(toolCall.Function.Name, []byte(toolCall.Function.Arguments))
+			if tracker.shouldStop(repeats) {
+				o.logger.Warn(fmt.Sprintf("stopping agent: the model repeated the identical %s tool call %d times in a row", toolCall.Function.Name, repeats))
+				return nil
 			}
+			calls = append(calls, toolInvocation{
+				id:      toolCall.ID,
+				name:    toolCall.Function.Name,
+				input:   []byte(toolCall.Function.Arguments),
+				repeats: repeats,
+			})
+		}
 
-			if err != nil {
-				return fmt.Errorf("failed to execute tool %s: %w", toolCall.Function.Name, err)
-			}
+		results, err := runToolCalls(calls, o.config.MaxParallelTools, func(call toolInvocation) (*ToolResult, error) {
+			return cache.run(call, func() (*ToolResult, error) {
+				return executeTool(o.logger, o.ignorer, o.config, call.name, call.input)
+			})
+		})
+		if err != nil {
+			return err
+		}
 
+		for i, call := range calls {
+			result := results[i]
 			resultStr := fmt.Sprintf("tool result: %+v", result.Content)
 			if len(resultStr) > 10000 {
 				resultStr = resultStr[:10000] + "..."
 			}
-			o.logger.Info(resultStr)
+			o.logger.Debug(resultStr)
+			if tracker.shouldWarn(call.repeats) {
+				result.Content = fmt.Sprintf("%v\n\n%s", result.Content, tracker.repetitionWarning(call.name))
+			}
 
-			result.ToolUseID = toolCall.ID
+			result.ToolUseID = call.id
 
 			// Add assistant message for tool call
 			assistantMsg = append(assistantMsg, oai.ChatCompletionAssistantMessageParam{
 				Role: oai.F(oai.ChatCompletionAssistantMessageParamRoleAssistant),
 				ToolCalls: oai.F([]oai.ChatCompletionMessageToolCallParam{
 					{
-						ID:   oai.F(toolCall.ID),
+						ID:   oai.F(call.id),
 						Type: oai.F(oai.ChatCompletionMessageToolCallTypeFunction),
 						Function: oai.F(oai.ChatCompletionMessageToolCallFunctionParam{
-							Name:      oai.F(toolCall.Function.Name),
-							Arguments: oai.F(toolCall.Function.Arguments),
+							Name:      oai.F(call.name),
+							Arguments: oai.F(string(call.input)),
 						}),
 					},
 				}),
@@ -204,12 +308,12 @@ func (o *openaiExecutor) Execute(input string) error {
 				return fmt.Errorf("failed to marshal tool result: %w", unmarshallErr)
 			}
 
-			assistantMsg = append(assistantMsg, oai.ToolMessage(toolCall.ID, string(content)))
+			assistantMsg = append(assistantMsg, oai.ToolMessage(call.id, string(content)))
 		}
 
 		// Add messages and continue conversation
 		params.Messages = oai.F(append(params.Messages.Value, assistantMsg...))
 	}
 
-	return nil
+	return checkJSONResponse(o.config, o.response)
 }
@@ -0,0 +1,108 @@
+package agent
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"testing"
+
+	gitignore "github.com/sabhiram/go-gitignore"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// openaiContentResponse returns a chat completion response that ends the agent loop with the given content
+func openaiContentResponse(content string) string {
+	encoded, _ := json.Marshal(content)
+	return fmt.Sprintf(`{
+	"id": "chatcmpl-1",
+	"object": "chat.completion",
+	"created": 1,
+	"model": "gpt-4o",
+	"choices": [{"index": 0, "finish_reason": "stop", "message": {"role": "assistant", "content": %s}}],
+	"usage": {"prompt_tokens": 1, "completion_tokens": 1, "total_tokens": 2}
+}`, encoded)
+}
+
+func TestOpenAIExecutorResponseFormat(t *testing.T) {
+	schema := map[string]any{
+		"type":       "object",
+		"properties": map[string]any{"summary": map[string]any{"type": "string"}},
+	}
+
+	tests := []struct {
+		name           string
+		config         GenConfig
+		response       string
+		wantFormatJSON string
+		wantErr        string
+	}{
+		{
+			name:     "text by default",
+			config:   GenConfig{Model: "gpt-4o"},
+			response: "not json",
+		},
+		{
+			name:           "json object",
+			config:         GenConfig{Model: "gpt-4o", ResponseFormat: ResponseFormatJSONObject},
+			response:       `{"summary": "done"}`,
+			wantFormatJSON: `{"type": "json_object"}`,
+		},
+		{
+			name:           "json schema",
+			config:         GenConfig{Model: "gpt-4o", ResponseFormat: ResponseFormatJSONSchema, ResponseSchema: schema},
+			response:       `{"summary": "done"}`,
+			wantFormatJSON: `{"type": "json_schema", "json_schema": {"name": "response", "strict": true, "schema": {"type": "object", "properties": {"summary": {"type": "string"}}}}}`,
+		},
+		{
+			name:           "invalid json response",
+			config:         GenConfig{Model: "gpt-4o", ResponseFormat: ResponseFormatJSONObject},
+			response:       "Here is the JSON you asked for",
+			wantFormatJSON: `{"type": "json_object"}`,
+			wantErr:        "the final response is not valid JSON",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var req struct {
+				ResponseFormat json.RawMessage `json:"response_format"`
+				Messages       []struct {
+					Role    string `json:"role"`
+					Content []struct {
+						Text string `json:"text"`
+					} `json:"content"`
+				} `json:"messages"`
+			}
+			server := newStubServer(t, openaiContentResponse(tt.response))
+
+			logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+			executor, err := NewOpenAIExecutor(server.URL, "test-key", nil, logger, gitignore.CompileIgnoreLines(), tt.config)
+			require.NoError(t, err)
+
+			err = executor.Execute("summarize the repo")
+			if tt.wantErr != "" {
+				assert.ErrorContains(t, err, tt.wantErr)
+			} else {
+				assert.NoError(t, err)
+			}
+
+			requests := server.requests()
+			require.Len(t, requests, 1)
+			require.NoError(t, json.Unmarshal([]byte(requests[0]), &req))
+
+			require.NotEmpty(t, req.Messages)
+			require.Equal(t, "system", req.Messages[0].Role)
+			require.NotEmpty(t, req.Messages[0].Content)
+			systemMessage := req.Messages[0].Content[0].Text
+			if tt.wantFormatJSON == "" {
+				assert.Empty(t, req.ResponseFormat)
+				assert.NotContains(t, systemMessage, jsonResponseInstructions)
+				return
+			}
+			assert.JSONEq(t, tt.wantFormatJSON, string(req.ResponseFormat))
+			assert.Contains(t, systemMessage, jsonResponseInstructions)
+		})
+	}
+}
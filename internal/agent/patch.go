@@ -0,0 +1,328 @@
+package agent
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var applyPatchTool = Tool{
+	Name: "apply_patch",
+	Description: `A tool to apply a unified diff (as produced by "diff -u" or "git diff") to one or more files at once
+* The patch is applied atomically: either every hunk of every file applies and all files are written, or nothing is changed
+* Each hunk must include enough unchanged context lines to locate it, and its context and removed lines must match the current file contents EXACTLY
+* Use "--- /dev/null" to create a file and "+++ /dev/null" to delete one
+* Paths are relative to the current directory, and may carry the "a/" and "b/" prefixes used by git
+* Prefer this tool over many "str_replace" calls of the "file_editor" tool when making several related changes`,
+	InputSchema: map[string]interface{}{
+		"type": "object",
+		"properties": map[string]interface{}{
+			"patch": map[string]interface{}{
+				"type":        "string",
+				"description": "The unified diff to apply.",
+			},
+		},
+		"required": []string{"patch"},
+	},
+}
+
+// filePatch holds the hunks of a unified diff that apply to a single file
+type filePatch struct {
+	oldPath string
+	newPath string
+	hunks   []patchHunk
+}
+
+// patchHunk is a single "@@" section of a unified diff
+type patchHunk struct {
+	header   string
+	oldStart int
+	oldLines []string
+	newLines []string
+	// oldNoEOL and newNoEOL record a "\ No newline at end of file" marker on the old and new side of the hunk
+	oldNoEOL bool
+	newNoEOL bool
+}
+
+var hunkHeaderRegex = regexp.MustCompile(`^@@ -(\d+)(?:,(\d+))? \+(\d+)(?:,(\d+))? @@`)
+
+// parsePatch parses a unified diff, which may span several files
+func parsePatch(patch string) ([]filePatch, error) {
+	lines := strings.Split(strings.ReplaceAll(patch, "\r\n", "\n"), "\n")
+	var patches []filePatch
+	for i := 0; i < len(lines); i++ {
+		if !strings.HasPrefix(lines[i], "--- ") {
+			continue
+		}
+		if i+1 >= len(lines) || !strings.HasPrefix(lines[i+1], "+++ ") {
+			return nil, fmt.Errorf("line %d: expected a \"+++\" line after %q", i+2, lines[i])
+		}
+		fp := filePatch{
+			oldPath: patchPath(lines[i][4:], "a/"),
+			newPath: patchPath(lines[i+1][4:], "b/"),
+		}
+		i += 2
+
+		for i < len(lines) && strings.HasPrefix(lines[i], "@@") {
+			m := hunkHeaderRegex.FindStringSubmatch(lines[i])
+			if m == nil {
+				return nil, fmt.Errorf("line %d: invalid hunk header %q", i+1, lines[i])
+			}
+			hunk := patchHunk{header: lines[i]}
+			hunk.oldStart, _ = strconv.Atoi(m[1])
+			oldCount, newCount := hunkCount(m[2]), hunkCount(m[4])
+			i++
+
+			var last byte
+			for i < len(lines) && (len(hunk.oldLines) < oldCount || len(hunk.newLines) < newCount || strings.HasPrefix(lines[i], `\`)) {
+				line := lines[i]
+				i++
+				if line == "" {
+					// some tools strip the trailing space of empty context lines
+					line = " "
+				}
+				switch line[0] {
+				case ' ':
+					hunk.oldLines = append(hunk.oldLines, line[1:])
+					hunk.newLines = append(hunk.newLines, line[1:])
+				case '-':
+					hunk.oldLines = append(hunk.oldLines, line[1:])
+				case '+':
+					hunk.newLines = append(hunk.newLines, line[1:])
+				case '\\':
+					if last != '+' {
+						hunk.oldNoEOL = true
+					}
+					if last != '-' {
+						hunk.newNoEOL = true
+					}
+					continue
+				default:
+					return nil, fmt.Errorf("line %d: unexpected line %q in hunk %q", i, line, hunk.header)
+				}
+				last = line[0]
+			}
+			if len(hunk.oldLines) != oldCount || len(hunk.newLines) != newCount {
+				return nil, fmt.Errorf("hunk %q of %s is truncated", hunk.header, fp.displayPath())
+			}
+			fp.hunks = append(fp.hunks, hunk)
+		}
+		i--
+
+		if len(fp.hunks) == 0 {
+			return nil, fmt.Errorf("patch for %s has no hunks", fp.displayPath())
+		}
+		patches = append(patches, fp)
+	}
+
+	if len(patches) == 0 {
+		return nil, fmt.Errorf("no file changes found, the patch must be a unified diff with \"---\", \"+++\" and \"@@\" lines")
+	}
+	return patches, nil
+}
+
+// hunkCount parses the optional line count of a hunk header range, which defaults to 1
+func hunkCount(s string) int {
+	if s == "" {
+		return 1
+	}
+	n, _ := strconv.Atoi(s)
+	return n
+}
+
+// patchPath extracts the file path from a "---" or "+++" line, dropping any timestamp and the git prefix
+func patchPath(s, gitPrefix string) string {
+	if i := strings.IndexByte(s, '\t'); i >= 0 {
+		s = s[:i]
+	}
+	s = strings.TrimSpace(s)
+	if s == "/dev/null" {
+		return s
+	}
+	return strings.TrimPrefix(s, gitPrefix)
+}
+
+// anchor returns the zero based index of the line the hunk's old side starts at. A hunk that removes nothing
+// ("@@ -N,0 ...") inserts its lines after line N rather than at it
+func (h patchHunk) anchor() int {
+	if len(h.oldLines) == 0 {
+		return h.oldStart
+	}
+	return max(h.oldStart-1, 0)
+}
+
+func (fp filePatch) displayPath() string {
+	if fp.newPath == "/dev/null" {
+		return fp.oldPath
+	}
+	return fp.newPath
+}
+
+// applyHunks applies the hunks in order to content. Each hunk is located by its context and removed lines,
+// searching outward from the line number in its header to tolerate earlier changes to the file
+func applyHunks(content string, hunks []patchHunk) (string, error) {
+	var lines []string
+	endsWithNewline := true
+	if content != "" {
+		endsWithNewline = strings.HasSuffix(content, "\n")
+		lines = strings.Split(strings.TrimSuffix(content, "\n"), "\n")
+	}
+
+	var result []string
+	pos, offset := 0, 0
+	for _, hunk := range hunks {
+		expected := hunk.anchor() + offset
+		start := findHunk(lines, hunk.oldLines, pos, expected)
+		if start < 0 {
+			return "", fmt.Errorf("hunk %q does not match the current file contents", hunk.header)
+		}
+		result = append(result, lines[pos:start]...)
+		result = append(result, hunk.newLines...)
+		pos = start + len(hunk.oldLines)
+		offset = start - hunk.anchor()
+
+		if pos == len(lines) {
+			if hunk.newNoEOL {
+				endsWithNewline = false
+			} else if hunk.oldNoEOL {
+				endsWithNewline = true
+			}
+		}
+	}
+	result = append(result, lines[pos:]...)
+
+	if len(result) == 0 {
+		return "", nil
+	}
+	out := strings.Join(result, "\n")
+	if endsWithNewline {
+		out += "\n"
+	}
+	return out, nil
+}
+
+// findHunk returns the index of the occurrence of want in lines, at or after from, that is closest to expected,
+// or -1 if there is none
+func findHunk(lines, want []string, from, expected int) int {
+	matches := func(start int) bool {
+		if start < from || start+len(want) > len(lines) {
+			return false
+		}
+		for i, line := range want {
+			if lines[start+i] != line {
+				return false
+			}
+		}
+		return true
+	}
+	for delta := 0; expected-delta >= from || expected+delta <= len(lines); delta++ {
+		if matches(expected - delta) {
+			return expected - delta
+		}
+		if matches(expected + delta) {
+			return expected + delta
+		}
+	}
+	return -1
+}
+
+// resolvePatchPath joins a path from a patch onto root, rejecting paths that would escape it
+func resolvePatchPath(root, path string) (string, error) {
+	if filepath.IsAbs(path) || !filepath.IsLocal(filepath.FromSlash(path)) {
+		return "", fmt.Errorf("path %s is outside the current directory", path)
+	}
+	return filepath.Join(root, filepath.FromSlash(path)), nil
+}
+
+// applyPatch applies a unified diff to the files under root. Every hunk is checked against the current file
+// contents before anything is written, so the patch is applied in full or not at all
+func applyPatch(root, patch string) (*ToolResult, error) {
+	patches, err := parsePatch(patch)
+	if err != nil {
+		return &ToolResult{Content: fmt.Sprintf("Error parsing patch: %s", err), IsError: true}, nil
+	}
+
+	// change is the new content of a file, or its removal when remove is set
+	type change struct {
+		path     string
+		content  string
+		remove   bool
+		existed  bool
+		original []byte
+	}
+	var changes []change
+	seen := make(map[string]bool)
+	for _, fp := range patches {
+		create, remove := fp.oldPath == "/dev/null", fp.newPath == "/dev/null"
+		if !remove && !create && fp.oldPath != fp.newPath {
+			return &ToolResult{Content: fmt.Sprintf("Error applying patch: renaming %s to %s is not supported", fp.oldPath, fp.newPath), IsError: true}, nil
+		}
+		path, err := resolvePatchPath(root, fp.displayPath())
+		if err != nil {
+			return &ToolResult{Content: fmt.Sprintf("Error applying patch: %s", err), IsError: true}, nil
+		}
+		// every section is applied to the file as it is on disk, so a second section for the same file would
+		// silently discard the first
+		if seen[path] {
+			return &ToolResult{Content: fmt.Sprintf("Error applying patch: %s appears more than once, put all of its hunks under a single \"---\"/\"+++\" header", fp.displayPath()), IsError: true}, nil
+		}
+		seen[path] = true
+
+		original, err := os.ReadFile(path)
+		existed := err == nil
+		switch {
+		case err != nil && !errors.Is(err, fs.ErrNotExist):
+			return &ToolResult{Content: fmt.Sprintf("Error reading file: %s", err), IsError: true}, nil
+		case create && existed:
+			return &ToolResult{Content: fmt.Sprintf("Error applying patch: cannot create %s, it already exists", fp.displayPath()), IsError: true}, nil
+		case !create && !existed:
+			return &ToolResult{Content: fmt.Sprintf("Error applying patch: %s does not exist", fp.displayPath()), IsError: true}, nil
+		}
+
+		content, err := applyHunks(string(original), fp.hunks)
+		if err != nil {
+			return &ToolResult{Content: fmt.Sprintf("Error applying patch to %s: %s. No files were changed", fp.displayPath(), err), IsError: true}, nil
+		}
+		if remove && content != "" {
+			return &ToolResult{Content: fmt.Sprintf("Error applying patch: the deletion of %s does not remove all of its contents. No files were changed", fp.displayPath()), IsError: true}, nil
+		}
+		changes = append(changes, change{path: path, content: content, remove: remove, existed: existed, original: original})
+	}
+
+	// restore undoes the changes written so far if a later write fails
+	restore := func(written []change) {
+		for _, c := range written {
+			if c.existed {
+				os.WriteFile(c.path, c.original, 0644)
+			} else {
+				os.Remove(c.path)
+			}
+		}
+	}
+	var changed []string
+	for i, c := range changes {
+		var err error
+		if c.remove {
+			err = os.Remove(c.path)
+		} else {
+			if err = os.MkdirAll(filepath.Dir(c.path), 0755); err == nil {
+				err = os.WriteFile(c.path, []byte(c.content), 0644)
+			}
+		}
+		if err != nil {
+			restore(changes[:i])
+			return &ToolResult{Content: fmt.Sprintf("Error writing patched files: %s. No files were changed", err), IsError: true}, nil
+		}
+		rel, _ := filepath.Rel(root, c.path)
+		changed = append(changed, filepath.ToSlash(rel))
+	}
+
+	return &ToolResult{
+		Content: fmt.Sprintf("Successfully applied patch to %s", strings.Join(changed, ", ")),
+	}, nil
+}
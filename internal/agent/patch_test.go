@@ -0,0 +1,224 @@
+package agent
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// writeFiles creates the files under root, keyed by their slash separated relative path
+func writeFiles(t *testing.T, root string, files map[string]string) {
+	t.Helper()
+	for path, content := range files {
+		full := filepath.Join(root, filepath.FromSlash(path))
+		require.NoError(t, os.MkdirAll(filepath.Dir(full), 0755))
+		require.NoError(t, os.WriteFile(full, []byte(content), 0644))
+	}
+}
+
+// readFiles returns the contents of every file under root, keyed by their slash separated relative path
+func readFiles(t *testing.T, root string) map[string]string {
+	t.Helper()
+	files := map[string]string{}
+	err := filepath.WalkDir(root, func(path string, d os.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(root, path)
+		files[filepath.ToSlash(rel)] = string(content)
+		return err
+	})
+	require.NoError(t, err)
+	return files
+}
+
+func TestApplyPatch(t *testing.T) {
+	original := map[string]string{
+		"main.go":        "package main\n\nimport \"fmt\"\n\nfunc main() {\n\tfmt.Println(\"hello\")\n}\n",
+		"util/util.go":   "package util\n\nfunc A() {}\n\nfunc B() {}\n\nfunc C() {}\n",
+		"obsolete.txt":   "remove me\n",
+		"no_newline.txt": "first\nlast",
+	}
+
+	tests := []struct {
+		name     string
+		patch    string
+		expected map[string]string
+		wantErr  string
+	}{
+		{
+			name: "multi-file patch",
+			patch: `diff --git a/main.go b/main.go
+index 1111111..2222222 100644
+--- a/main.go
++++ b/main.go
+@@ -3,5 +3,5 @@ package main
+ import "fmt"
+ 
+ func main() {
+-	fmt.Println("hello")
++	fmt.Println("hello, world")
+ }
+--- a/util/util.go
++++ b/util/util.go
+@@ -1,3 +1,4 @@
+ package util
+ 
++// A does nothing
+ func A() {}
+@@ -6,2 +7,2 @@
+ 
+-func C() {}
++func C() { A() }
+--- /dev/null
++++ b/util/new.go
+@@ -0,0 +1,3 @@
++package util
++
++func D() {}
+--- a/obsolete.txt
++++ /dev/null
+@@ -1 +0,0 @@
+-remove me
+--- a/no_newline.txt
++++ b/no_newline.txt
+@@ -1,2 +1,2 @@
+ first
+-last
+\ No newline at end of file
++last
+`,
+			expected: map[string]string{
+				"main.go":        "package main\n\nimport \"fmt\"\n\nfunc main() {\n\tfmt.Println(\"hello, world\")\n}\n",
+				"util/util.go":   "package util\n\n// A does nothing\nfunc A() {}\n\nfunc B() {}\n\nfunc C() { A() }\n",
+				"util/new.go":    "package util\n\nfunc D() {}\n",
+				"no_newline.txt": "first\nlast\n",
+			},
+		},
+		{
+			name: "hunk with a stale line number is located by its context",
+			patch: `--- a/util/util.go
++++ b/util/util.go
+@@ -1,3 +1,3 @@
+ func B() {}
+ 
+-func C() {}
++func C() { B() }
+`,
+			expected: map[string]string{
+				"main.go":        original["main.go"],
+				"util/util.go":   "package util\n\nfunc A() {}\n\nfunc B() {}\n\nfunc C() { B() }\n",
+				"obsolete.txt":   original["obsolete.txt"],
+				"no_newline.txt": original["no_newline.txt"],
+			},
+		},
+		{
+			name: "pure insertion goes after the line in its header",
+			patch: `--- a/util/util.go
++++ b/util/util.go
+@@ -3,0 +4,2 @@
++
++func AB() {}
+@@ -7,0 +10,2 @@
++
++func D() {}
+`,
+			expected: map[string]string{
+				"main.go":        original["main.go"],
+				"util/util.go":   "package util\n\nfunc A() {}\n\nfunc AB() {}\n\nfunc B() {}\n\nfunc C() {}\n\nfunc D() {}\n",
+				"obsolete.txt":   original["obsolete.txt"],
+				"no_newline.txt": original["no_newline.txt"],
+			},
+		},
+		{
+			name: "several sections for the same file",
+			patch: `--- a/util/util.go
++++ b/util/util.go
+@@ -3 +3 @@
+-func A() {}
++func A() { B() }
+--- a/util/util.go
++++ b/util/util.go
+@@ -7 +7 @@
+-func C() {}
++func C() { B() }
+`,
+			wantErr: `Error applying patch: util/util.go appears more than once, put all of its hunks under a single "---"/"+++" header`,
+		},
+		{
+			name: "mismatched context rejects the whole patch",
+			patch: `--- a/main.go
++++ b/main.go
+@@ -5,3 +5,3 @@
+ func main() {
+-	fmt.Println("hello")
++	fmt.Println("hi")
+ }
+--- a/util/util.go
++++ b/util/util.go
+@@ -3,1 +3,1 @@
+-func A() { return }
++func A() {}
+`,
+			wantErr: `Error applying patch to util/util.go: hunk "@@ -3,1 +3,1 @@" does not match the current file contents. No files were changed`,
+		},
+		{
+			name: "creating an existing file",
+			patch: `--- /dev/null
++++ b/main.go
+@@ -0,0 +1 @@
++package main
+`,
+			wantErr: "Error applying patch: cannot create main.go, it already exists",
+		},
+		{
+			name: "path outside the current directory",
+			patch: `--- a/../secret.txt
++++ b/../secret.txt
+@@ -1 +1 @@
+-a
++b
+`,
+			wantErr: "Error applying patch: path ../secret.txt is outside the current directory",
+		},
+		{
+			name:    "not a diff",
+			patch:   "please change main.go",
+			wantErr: `Error parsing patch: no file changes found, the patch must be a unified diff with "---", "+++" and "@@" lines`,
+		},
+		{
+			name: "truncated hunk",
+			patch: `--- a/main.go
++++ b/main.go
+@@ -1,3 +1,3 @@
+ package main
+`,
+			wantErr: `Error parsing patch: hunk "@@ -1,3 +1,3 @@" of main.go is truncated`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			root := t.TempDir()
+			writeFiles(t, root, original)
+
+			result, err := applyPatch(root, tt.patch)
+			require.NoError(t, err)
+			if tt.wantErr != "" {
+				assert.True(t, result.IsError)
+				assert.Equal(t, tt.wantErr, result.Content)
+				assert.Equal(t, original, readFiles(t, root), "a rejected patch should not change any file")
+				return
+			}
+			assert.False(t, result.IsError, result.Content)
+			assert.Equal(t, tt.expected, readFiles(t, root))
+		})
+	}
+}
@@ -0,0 +1,82 @@
+package agent
+
+import (
+	"fmt"
+	"strings"
+)
+
+// responder is implemented by executors that keep the text of the model's final response to the last input
+type responder interface {
+	finalResponse() string
+}
+
+func (s *anthropicExecutor) finalResponse() string { return s.response }
+
+func (o *openaiExecutor) finalResponse() string { return o.response }
+
+func (o *deepseekExecutor) finalResponse() string { return o.response }
+
+func (g *geminiExecutor) finalResponse() string { return g.response }
+
+// FinalResponse returns the text of the executor's final response to the last input, or an empty string if
+// the executor does not keep it
+func FinalResponse(e Executor) string {
+	if r, ok := e.(responder); ok {
+		return r.finalResponse()
+	}
+	return ""
+}
+
+// planningPrompt asks the model to investigate the task with read-only tools and end by emitting a plan
+const planningPrompt = `You are in planning mode. Only tools that cannot change the workspace are available.
+Investigate as needed, then reply with a concise, numbered, step by step plan to accomplish the task below.
+Do not attempt to carry out the plan, it will be reviewed before execution.
+
+Task:
+%s`
+
+// executionPrompt asks the model to carry out a plan that was approved by the user
+const executionPrompt = `Carry out the following approved plan to accomplish the task.
+
+Task:
+%s
+
+Plan:
+%s`
+
+// PlanThenExecute runs the input in two phases. In the planning phase, an executor created with readOnly set
+// only has access to non-mutating tools and ends by emitting a plan, so any response format is only enforced
+// on the execution phase. The plan is then passed to approve, and only if it is approved is a second executor
+// with the full tool set created to carry it out.
+func PlanThenExecute(newExecutor func(readOnly bool) (Executor, error), input string, approve func(plan string) (bool, error)) error {
+	planner, err := newExecutor(true)
+	if err != nil {
+		return fmt.Errorf("failed to create planning executor: %w", err)
+	}
+	if err := planner.Execute(fmt.Sprintf(planningPrompt, input)); err != nil {
+		return fmt.Errorf("failed to create plan: %w", err)
+	}
+
+	r, ok := planner.(responder)
+	if !ok {
+		return fmt.Errorf("executor does not support planning")
+	}
+	plan := r.finalResponse()
+	if strings.TrimSpace(plan) == "" {
+		return fmt.Errorf("the model did not produce a plan")
+	}
+
+	approved, err := approve(plan)
+	if err != nil {
+		return err
+	}
+	if !approved {
+		return nil
+	}
+
+	executor, err := newExecutor(false)
+	if err != nil {
+		return fmt.Errorf("failed to create executor: %w", err)
+	}
+	return executor.Execute(fmt.Sprintf(executionPrompt, input, plan))
+}
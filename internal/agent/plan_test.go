@@ -0,0 +1,105 @@
+package agent
+
+import (
+	"encoding/json"
+	"io"
+	"log/slog"
+	"testing"
+
+	a "github.com/anthropics/anthropic-sdk-go"
+	gitignore "github.com/sabhiram/go-gitignore"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPlanThenExecute(t *testing.T) {
+	type request struct {
+		Tools []struct {
+			Name string `json:"name"`
+		} `json:"tools"`
+		Messages []struct {
+			Content []struct {
+				Text string `json:"text"`
+			} `json:"content"`
+		} `json:"messages"`
+	}
+
+	tests := []struct {
+		name     string
+		approved bool
+	}{
+		{name: "approved plan is executed", approved: true},
+		{name: "rejected plan is not executed", approved: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := newStubServer(t, textResponse, textResponse)
+
+			logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+			newExecutor := func(readOnly bool) (Executor, error) {
+				return NewAnthropicExecutor(server.URL, "test-key", nil, logger, gitignore.CompileIgnoreLines(), GenConfig{
+					Model:     a.ModelClaude3_5Sonnet20241022,
+					MaxTokens: 1024,
+					ReadOnly:  readOnly,
+				})
+			}
+
+			var gotPlan string
+			err := PlanThenExecute(newExecutor, "add a flag", func(plan string) (bool, error) {
+				gotPlan = plan
+				return tt.approved, nil
+			})
+			require.NoError(t, err)
+			assert.Equal(t, "done", gotPlan)
+
+			var requests []request
+			for _, body := range server.requests() {
+				var req request
+				require.NoError(t, json.Unmarshal([]byte(body), &req))
+				requests = append(requests, req)
+			}
+
+			toolNames := func(req request) []string {
+				var names []string
+				for _, tool := range req.Tools {
+					names = append(names, tool.Name)
+				}
+				return names
+			}
+
+			if !tt.approved {
+				require.Len(t, requests, 1)
+			} else {
+				require.Len(t, requests, 2)
+				assert.ElementsMatch(t, []string{bashTool.Name, fileEditor.Name, filesOverviewTool.Name, getRelatedFilesTool.Name, applyPatchTool.Name, readSymbolTool.Name, gitDiffTool.Name}, toolNames(requests[1]))
+				assert.Contains(t, requests[1].Messages[0].Content[0].Text, "Plan:\ndone")
+			}
+			assert.ElementsMatch(t, []string{filesOverviewTool.Name, getRelatedFilesTool.Name, readSymbolTool.Name, gitDiffTool.Name}, toolNames(requests[0]))
+			assert.Contains(t, requests[0].Messages[0].Content[0].Text, "planning mode")
+		})
+	}
+}
+
+type stubExecutor struct{}
+
+func (stubExecutor) Execute(string) error { return nil }
+
+func TestFinalResponse(t *testing.T) {
+	server, _ := newStubAnthropicServer(t, func(int, []byte) string {
+		return textResponse
+	})
+
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	executor, err := NewAnthropicExecutor(server.URL, "test-key", nil, logger, gitignore.CompileIgnoreLines(), GenConfig{
+		Model:     a.ModelClaude3_5Sonnet20241022,
+		MaxTokens: 1024,
+	})
+	require.NoError(t, err)
+	assert.Empty(t, FinalResponse(executor))
+
+	require.NoError(t, executor.Execute("hello"))
+	assert.Equal(t, "done", FinalResponse(executor))
+
+	assert.Empty(t, FinalResponse(stubExecutor{}))
+}
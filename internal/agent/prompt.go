@@ -0,0 +1,51 @@
+package agent
+
+import (
+	"fmt"
+	"runtime"
+	"strings"
+)
+
+// osGuidance holds the shell and command guidance for each operating system, keyed by GOOS
+var osGuidance = map[string]string{
+	"linux": `Commands run on Linux with the GNU versions of the core utilities, e.g. "sed -i 's/a/b/' file" edits a file in place. ` +
+		`The package manager depends on the distribution, check /etc/os-release before installing packages.`,
+	"darwin": `Commands run on macOS, which ships the BSD versions of the core utilities rather than the GNU ones. ` +
+		`Avoid GNU-only flags, e.g. use "sed -i '' 's/a/b/' file" to edit a file in place, and use "brew" to install packages.`,
+	"windows": `Commands run on Windows. Avoid unix-only commands and utilities such as sed, grep, which or chmod; ` +
+		`use PowerShell cmdlets such as Get-Content, Select-String and Get-Command instead. ` +
+		`Paths use backslashes as separators, and "winget" can be used to install packages.`,
+}
+
+// buildSystemPrompt fills the {{environment}} placeholder of the agent instructions with guidance on the shell
+// environment of the given operating system (a GOOS value), so the commands the model runs match the user's environment
+func buildSystemPrompt(goos string) string {
+	guidance, ok := osGuidance[goos]
+	if !ok {
+		guidance = "Commands run on a POSIX-like system; prefer portable commands and options."
+	}
+	environment := fmt.Sprintf("The user's operating system is %s. %s", goos, guidance)
+	return strings.Replace(agentInstructions, "{{environment}}", environment, 1)
+}
+
+// systemPrompt is the system prompt sent to every provider
+var systemPrompt = buildSystemPrompt(runtime.GOOS)
+
+// jsonResponseInstructions are added to the system prompt when the final response must be JSON. Providers such as
+// OpenAI also reject JSON mode unless the messages ask for JSON
+const jsonResponseInstructions = "\n\nYour final response, after you have finished using tools, must be a single valid JSON value with no surrounding text or code fences."
+
+// systemPromptFor returns the system prompt for the given config
+func systemPromptFor(config GenConfig) string {
+	prompt := systemPrompt
+	if config.RepoContext != "" {
+		prompt += "\n\nThe state of the git repository in the current directory when the conversation started:\n" + config.RepoContext
+	}
+	if config.ProjectContext != "" {
+		prompt += "\n\nContext about the project provided by the user:\n" + config.ProjectContext
+	}
+	if jsonResponseRequested(config) {
+		prompt += jsonResponseInstructions
+	}
+	return prompt
+}
@@ -0,0 +1,49 @@
+package agent
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBuildSystemPrompt(t *testing.T) {
+	tests := []struct {
+		goos     string
+		contains []string
+		excludes []string
+	}{
+		{
+			goos:     "linux",
+			contains: []string{"operating system is linux", "GNU versions", "/etc/os-release"},
+			excludes: []string{"PowerShell", "brew"},
+		},
+		{
+			goos:     "darwin",
+			contains: []string{"operating system is darwin", "BSD versions", "sed -i ''", "brew"},
+			excludes: []string{"PowerShell", "/etc/os-release"},
+		},
+		{
+			goos:     "windows",
+			contains: []string{"operating system is windows", "Avoid unix-only commands", "PowerShell"},
+			excludes: []string{"brew", "/etc/os-release"},
+		},
+		{
+			goos:     "freebsd",
+			contains: []string{"operating system is freebsd", "portable commands"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.goos, func(t *testing.T) {
+			prompt := buildSystemPrompt(tt.goos)
+			assert.NotContains(t, prompt, "{{environment}}")
+			assert.Contains(t, prompt, "The user will now present the task.")
+			for _, s := range tt.contains {
+				assert.Contains(t, prompt, s)
+			}
+			for _, s := range tt.excludes {
+				assert.NotContains(t, prompt, s)
+			}
+		})
+	}
+}
@@ -0,0 +1,58 @@
+package agent
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// toolCallTracker detects when the model keeps issuing the identical tool call (same name and arguments) without making progress
+type toolCallTracker struct {
+	limit   int
+	lastKey string
+	count   int
+}
+
+func newToolCallTracker(limit int) *toolCallTracker {
+	return &toolCallTracker{limit: limit}
+}
+
+// toolCallKey identifies a tool call by its name and arguments.
+// The arguments are normalized through JSON so that key order and whitespace differences are ignored.
+func toolCallKey(name string, args []byte) string {
+	var v any
+	if err := json.Unmarshal(args, &v); err == nil {
+		if normalized, err := json.Marshal(v); err == nil {
+			return name + "\x00" + string(normalized)
+		}
+	}
+	return name + "\x00" + string(args)
+}
+
+// track records a tool call and returns the number of times in a row the identical call has now been issued
+func (t *toolCallTracker) track(name string, args []byte) int {
+	key := toolCallKey(name, args)
+	if key == t.lastKey {
+		t.count++
+	} else {
+		t.lastKey = key
+		t.count = 1
+	}
+	return t.count
+}
+
+// shouldWarn reports whether the model should be told it is repeating itself
+func (t *toolCallTracker) shouldWarn(repeats int) bool {
+	return t.limit > 0 && repeats == t.limit
+}
+
+// shouldStop reports whether the model ignored the warning and the session should be terminated
+func (t *toolCallTracker) shouldStop(repeats int) bool {
+	return t.limit > 0 && repeats > t.limit
+}
+
+// repetitionWarning is appended to the tool result once the model has repeated the same call too many times
+func (t *toolCallTracker) repetitionWarning(name string) string {
+	return fmt.Sprintf("NOTE: you have issued the identical %s tool call %d times in a row without making progress. "+
+		"Do not repeat this call again; try a different approach or finish with your answer. "+
+		"Repeating it once more will end the session.", name, t.limit)
+}
@@ -0,0 +1,191 @@
+package agent
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+var runTestsTool = Tool{
+	Name: "run_tests",
+	Description: `A tool to run the project's tests and get a summary of the results
+* Runs the test command configured by the user, e.g. "go test -json ./..." for Go modules
+* Returns whether the run passed, the number of passed, failed and skipped tests, the failing tests and their output
+* Output of passing tests is omitted, and long output is truncated
+* Prefer this tool over running the tests with the "bash" tool after making changes`,
+	InputSchema: map[string]interface{}{
+		"type":       "object",
+		"properties": map[string]interface{}{},
+	},
+}
+
+// defaultGoTestCommand is the test command used for Go modules when none is configured
+const defaultGoTestCommand = "go test -json ./..."
+
+// testTimeout is how long the run_tests tool lets the test command run before stopping it
+const testTimeout = 10 * time.Minute
+
+// maxTestOutputLength caps the length of the failing output returned to the model
+const maxTestOutputLength = 20000
+
+// resolveTestCommand returns the command the run_tests tool runs in dir: the configured one, or otherwise
+// defaultGoTestCommand when dir contains a go.mod. An empty result means the tool is not offered
+func resolveTestCommand(dir, configured string) string {
+	if configured != "" {
+		return configured
+	}
+	if _, err := os.Stat(filepath.Join(dir, "go.mod")); err == nil {
+		return defaultGoTestCommand
+	}
+	return ""
+}
+
+// goTestEvent is a single event of the output of "go test -json"
+type goTestEvent struct {
+	Action  string `json:"Action"`
+	Package string `json:"Package"`
+	Test    string `json:"Test"`
+	Output  string `json:"Output"`
+}
+
+// testSummary is the outcome of a test run
+type testSummary struct {
+	passed, failed, skipped int
+	failedTests             []string
+	failedPackages          []string
+	// output holds the output of failed tests, failed packages and anything that is not a test event, e.g. build errors
+	output strings.Builder
+}
+
+// summarizeGoTestOutput summarizes the output of "go test -json". It reports false if the output holds no test events,
+// e.g. because the configured command is not a Go test command
+func summarizeGoTestOutput(output []byte) (*testSummary, bool) {
+	var (
+		summary      testSummary
+		found        bool
+		testOutput   = map[string]*strings.Builder{}
+		failedByPkg  = map[string]bool{}
+		otherOutputs []string
+	)
+	scanner := bufio.NewScanner(strings.NewReader(string(output)))
+	scanner.Buffer(make([]byte, 0, 64*1024), 10<<20)
+	for scanner.Scan() {
+		line := scanner.Text()
+		var event goTestEvent
+		if !strings.HasPrefix(line, "{") || json.Unmarshal([]byte(line), &event) != nil || event.Action == "" {
+			if strings.TrimSpace(line) != "" {
+				otherOutputs = append(otherOutputs, line)
+			}
+			continue
+		}
+		found = true
+
+		key := event.Package + " " + event.Test
+		switch event.Action {
+		case "output", "build-output":
+			b, ok := testOutput[key]
+			if !ok {
+				b = &strings.Builder{}
+				testOutput[key] = b
+			}
+			b.WriteString(event.Output)
+		case "pass":
+			if event.Test != "" {
+				summary.passed++
+			}
+		case "skip":
+			if event.Test != "" {
+				summary.skipped++
+			}
+		case "fail":
+			if event.Test == "" {
+				summary.failedPackages = append(summary.failedPackages, event.Package)
+				break
+			}
+			summary.failed++
+			summary.failedTests = append(summary.failedTests, key)
+			failedByPkg[event.Package] = true
+		}
+	}
+	if !found {
+		return nil, false
+	}
+
+	for _, test := range summary.failedTests {
+		if b, ok := testOutput[test]; ok {
+			fmt.Fprintf(&summary.output, "=== %s\n%s", test, b.String())
+		}
+	}
+	// packages can fail without a failing test, e.g. when they do not build or a test panics outside of a test function
+	for _, pkg := range summary.failedPackages {
+		if !failedByPkg[pkg] {
+			if b, ok := testOutput[pkg+" "]; ok {
+				fmt.Fprintf(&summary.output, "=== %s\n%s", pkg, b.String())
+			}
+		}
+	}
+	if len(otherOutputs) > 0 && (summary.failed > 0 || len(summary.failedPackages) > 0) {
+		summary.output.WriteString(strings.Join(otherOutputs, "\n") + "\n")
+	}
+	return &summary, true
+}
+
+// runTests runs the test command in dir, stopping it after timeout, and returns a summary of the results.
+// Failing tests are reported in the content of the result rather than as an error, so that the model can fix them
+func runTests(dir, command string, timeout time.Duration) (*ToolResult, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	args := shellCommandArgs(commandShell, command)
+	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+	cmd.Dir = dir
+	cmd.Env = os.Environ()
+	// child processes of the shell can keep the output open after it is killed
+	cmd.WaitDelay = time.Second
+
+	output, err := cmd.CombinedOutput()
+	var exitErr *exec.ExitError
+	status := "PASS"
+	switch {
+	case errors.Is(ctx.Err(), context.DeadlineExceeded):
+		status = fmt.Sprintf("TIMEOUT (stopped after %s)", timeout)
+	case errors.As(err, &exitErr):
+		status = "FAIL"
+	case err != nil:
+		return &ToolResult{Content: fmt.Sprintf("Error running test command %q: %s\nOutput: %s", command, err, output), IsError: true}, nil
+	}
+
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "Command: %s\nStatus: %s\n", command, status)
+
+	failingOutput := string(output)
+	if summary, ok := summarizeGoTestOutput(output); ok {
+		fmt.Fprintf(&sb, "Tests: %d passed, %d failed, %d skipped\n", summary.passed, summary.failed, summary.skipped)
+		if len(summary.failedTests) > 0 {
+			fmt.Fprintf(&sb, "Failed tests:\n- %s\n", strings.Join(summary.failedTests, "\n- "))
+		}
+		if len(summary.failedPackages) > 0 {
+			fmt.Fprintf(&sb, "Failed packages:\n- %s\n", strings.Join(summary.failedPackages, "\n- "))
+		}
+		failingOutput = summary.output.String()
+	} else if status == "PASS" {
+		// the output of a passing run of an unknown test command is not needed
+		failingOutput = ""
+	}
+
+	if failingOutput != "" {
+		if runes := []rune(failingOutput); len(runes) > maxTestOutputLength {
+			failingOutput = string(runes[:maxTestOutputLength]) + "\n\n[truncated]"
+		}
+		fmt.Fprintf(&sb, "Output:\n%s", failingOutput)
+	}
+
+	return &ToolResult{Content: sb.String()}, nil
+}
@@ -0,0 +1,106 @@
+package agent
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRunTests(t *testing.T) {
+	if _, err := exec.LookPath("go"); err != nil {
+		t.Skip("go toolchain not available")
+	}
+
+	dir := t.TempDir()
+	files := map[string]string{
+		"go.mod": "module example.com/sample\n\ngo 1.21\n",
+		"sample_test.go": `package sample
+
+import "testing"
+
+func TestPass(t *testing.T) {
+	t.Log("passing output")
+}
+
+func TestFail(t *testing.T) {
+	t.Fatal("boom")
+}
+`,
+	}
+	for name, content := range files {
+		require.NoError(t, os.WriteFile(filepath.Join(dir, name), []byte(content), 0644))
+	}
+
+	result, err := runTests(dir, defaultGoTestCommand, time.Minute)
+	require.NoError(t, err)
+	assert.False(t, result.IsError)
+
+	content := result.Content.(string)
+	assert.Contains(t, content, "Status: FAIL")
+	assert.Contains(t, content, "Tests: 1 passed, 1 failed, 0 skipped")
+	assert.Contains(t, content, "Failed tests:\n- example.com/sample TestFail\n")
+	assert.Contains(t, content, "boom")
+	assert.NotContains(t, content, "passing output")
+}
+
+func TestRunTestsNonGoCommand(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("uses POSIX shell commands")
+	}
+
+	tests := []struct {
+		name    string
+		command string
+		timeout time.Duration
+		want    []string
+		notWant []string
+	}{
+		{
+			name:    "passing command omits output",
+			command: "echo all $((1 + 1)) good",
+			timeout: time.Minute,
+			want:    []string{"Status: PASS"},
+			notWant: []string{"all 2 good"},
+		},
+		{
+			name:    "failing command includes output",
+			command: "echo something broke; exit 1",
+			timeout: time.Minute,
+			want:    []string{"Status: FAIL", "something broke"},
+		},
+		{
+			name:    "slow command is stopped",
+			command: "sleep 10",
+			timeout: 100 * time.Millisecond,
+			want:    []string{"Status: TIMEOUT"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := runTests(t.TempDir(), tt.command, tt.timeout)
+			require.NoError(t, err)
+			for _, want := range tt.want {
+				assert.Contains(t, result.Content, want)
+			}
+			for _, notWant := range tt.notWant {
+				assert.NotContains(t, result.Content, notWant)
+			}
+		})
+	}
+}
+
+func TestResolveTestCommand(t *testing.T) {
+	goModule := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(goModule, "go.mod"), []byte("module example.com/sample\n"), 0644))
+
+	assert.Equal(t, defaultGoTestCommand, resolveTestCommand(goModule, ""))
+	assert.Equal(t, "make test", resolveTestCommand(goModule, "make test"))
+	assert.Equal(t, "", resolveTestCommand(t.TempDir(), ""))
+}
@@ -0,0 +1,68 @@
+package agent
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+	"strings"
+)
+
+// resolveShell returns the shell that commands of the bash tool run with: the one configured through the
+// CPE_SHELL environment variable, or otherwise PowerShell on Windows and bash everywhere else
+func resolveShell(goos, configured string) string {
+	if configured != "" {
+		return configured
+	}
+	if goos == "windows" {
+		return "powershell"
+	}
+	return "bash"
+}
+
+// shellName returns the lower case name of a shell program without its directory or .exe suffix,
+// e.g. "pwsh" for C:\Program Files\PowerShell\7\pwsh.exe
+func shellName(shell string) string {
+	name := shell[strings.LastIndexAny(shell, `/\`)+1:]
+	return strings.TrimSuffix(strings.ToLower(name), ".exe")
+}
+
+// shellCommandArgs returns the program and arguments that run command with shell
+func shellCommandArgs(shell, command string) []string {
+	switch shellName(shell) {
+	case "powershell", "pwsh":
+		return []string{shell, "-NoProfile", "-NonInteractive", "-Command", command}
+	case "cmd":
+		return []string{shell, "/C", command}
+	default:
+		return []string{shell, "-c", command}
+	}
+}
+
+// bashToolDescription describes the bash tool for the shell that commands run with
+func bashToolDescription(shell string) string {
+	var lineRangeTip, backgroundTip string
+	switch shellName(shell) {
+	case "powershell", "pwsh":
+		lineRangeTip = "'Get-Content path\\to\\file | Select-Object -Skip 9 -First 16'"
+		backgroundTip = "'Start-Process' or 'Start-Job'"
+	case "cmd":
+		lineRangeTip = "'more +9 path\\to\\file' with a limited output"
+		backgroundTip = "'start /b'"
+	default:
+		lineRangeTip = "'sed -n 10,25p /path/to/the/file'"
+		backgroundTip = "'sleep 10 &'"
+	}
+
+	return fmt.Sprintf(`Run commands in a %s shell
+* When invoking this tool, the contents of the "command" parameter does NOT need to be escaped.
+* You can access the internet via this tool with CLI's like "curl" or "wget" command.
+* You can install the necessary dependencies for your project with this tool, e.g. "pip install", "npm install", "apt-get install", "brew install", etc.
+* State is persistent across command calls.
+* To inspect a particular line range of a file, e.g. lines 10-25, try %s.
+* The result starts with the command's exit code, followed by its combined stdout and stderr output.
+* Avoid commands that may produce a very large amount of output.
+* Run long lived commands in the background, e.g. %s or start a server in the background`, shellName(shell), lineRangeTip, backgroundTip)
+}
+
+// commandShell is the shell that commands of the bash tool run with
+var commandShell = resolveShell(runtime.GOOS, os.Getenv("CPE_SHELL"))
@@ -0,0 +1,90 @@
+package agent
+
+import (
+	"runtime"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestShellCommandArgs(t *testing.T) {
+	tests := []struct {
+		name       string
+		goos       string
+		configured string
+		expected   []string
+	}{
+		{
+			name:     "bash by default",
+			goos:     "linux",
+			expected: []string{"bash", "-c", "ls"},
+		},
+		{
+			name:     "powershell on windows",
+			goos:     "windows",
+			expected: []string{"powershell", "-NoProfile", "-NonInteractive", "-Command", "ls"},
+		},
+		{
+			name:       "configured cmd on windows",
+			goos:       "windows",
+			configured: `C:\Windows\System32\cmd.exe`,
+			expected:   []string{`C:\Windows\System32\cmd.exe`, "/C", "ls"},
+		},
+		{
+			name:       "configured pwsh",
+			goos:       "darwin",
+			configured: "/usr/local/bin/pwsh",
+			expected:   []string{"/usr/local/bin/pwsh", "-NoProfile", "-NonInteractive", "-Command", "ls"},
+		},
+		{
+			name:       "configured posix shell",
+			goos:       "darwin",
+			configured: "/bin/zsh",
+			expected:   []string{"/bin/zsh", "-c", "ls"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, shellCommandArgs(resolveShell(tt.goos, tt.configured), "ls"))
+		})
+	}
+}
+
+func TestBashToolDescription(t *testing.T) {
+	assert.Contains(t, bashToolDescription("bash"), "Run commands in a bash shell")
+	assert.Contains(t, bashToolDescription("bash"), "sed -n 10,25p")
+
+	description := bashToolDescription("powershell")
+	assert.Contains(t, description, "Run commands in a powershell shell")
+	assert.Contains(t, description, "Get-Content")
+	assert.NotContains(t, description, "sed -n")
+}
+
+func TestExecuteBashToolShell(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("requires a POSIX shell")
+	}
+
+	// $0 expands to the name of the shell running the command
+	result, err := executeBashTool("sh", ".", "echo $0")
+	require.NoError(t, err)
+	assert.False(t, result.IsError)
+	assert.Equal(t, "Exit code: 0\nOutput: sh\n", result.Content)
+
+	result, err = executeBashTool("sh", ".", "echo failing; exit 3")
+	require.NoError(t, err)
+	assert.True(t, result.IsError)
+	assert.Equal(t, "Error executing command: exit status 3\nExit code: 3\nOutput: failing\n", result.Content)
+
+	result, err = executeBashTool("sh", ".", "echo to stderr >&2; false")
+	require.NoError(t, err)
+	assert.True(t, result.IsError)
+	assert.Equal(t, "Error executing command: exit status 1\nExit code: 1\nOutput: to stderr\n", result.Content)
+
+	result, err = executeBashTool("no-such-shell", ".", "true")
+	require.NoError(t, err)
+	assert.True(t, result.IsError)
+	assert.NotContains(t, result.Content, "Exit code")
+}
@@ -0,0 +1,42 @@
+package agent
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+)
+
+// spillPreviewBytes is the size of the head and of the tail of a spilled tool result kept in the conversation
+const spillPreviewBytes = 2000
+
+// spillToolResult writes the content of a tool result larger than threshold bytes to a new file in dir, and
+// returns a result holding only the file path and the head and tail of the content. Smaller results are
+// returned unchanged
+func spillToolResult(logger *slog.Logger, name string, result *ToolResult, threshold int, dir string) (*ToolResult, error) {
+	content := fmt.Sprintf("%v", result.Content)
+	if threshold <= 0 || len(content) <= threshold {
+		return result, nil
+	}
+
+	f, err := os.CreateTemp(dir, "cpe-"+name+"-*.txt")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create file for the %s tool output: %w", name, err)
+	}
+	defer f.Close()
+	if _, err := f.WriteString(content); err != nil {
+		return nil, fmt.Errorf("failed to write the %s tool output to %s: %w", name, f.Name(), err)
+	}
+	logger.Info(fmt.Sprintf("saved %d bytes of %s tool output to %s", len(content), name, f.Name()))
+
+	preview := min(spillPreviewBytes, threshold/2)
+	head := strings.ToValidUTF8(content[:preview], "")
+	tail := strings.ToValidUTF8(content[len(content)-preview:], "")
+	return &ToolResult{
+		ToolUseID: result.ToolUseID,
+		Content: fmt.Sprintf("The output is %d bytes, too large to include in full. The full output was saved to %s, "+
+			"inspect it with other tools if needed.\n\nStart of the output:\n%s\n\n[...]\n\nEnd of the output:\n%s",
+			len(content), f.Name(), head, tail),
+		IsError: result.IsError,
+	}, nil
+}
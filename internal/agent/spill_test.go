@@ -0,0 +1,63 @@
+package agent
+
+import (
+	"io"
+	"log/slog"
+	"os"
+	"regexp"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSpillToolResult(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	small := &ToolResult{ToolUseID: "toolu_1", Content: "ok"}
+	result, err := spillToolResult(logger, bashTool.Name, small, 100, t.TempDir())
+	require.NoError(t, err)
+	assert.Same(t, small, result)
+
+	dir := t.TempDir()
+	content := "HEAD" + strings.Repeat("middle ", 2000) + "TAIL"
+	result, err = spillToolResult(logger, bashTool.Name, &ToolResult{ToolUseID: "toolu_2", Content: content, IsError: true}, 1000, dir)
+	require.NoError(t, err)
+	assert.Equal(t, "toolu_2", result.ToolUseID)
+	assert.True(t, result.IsError)
+
+	summary := result.Content.(string)
+	assert.Less(t, len(summary), 1500)
+	assert.Contains(t, summary, "The output is 14008 bytes")
+	assert.Contains(t, summary, "Start of the output:\nHEAD")
+	assert.True(t, strings.HasSuffix(summary, "TAIL"))
+
+	path := regexp.MustCompile(`saved to (\S+),`).FindStringSubmatch(summary)
+	require.Len(t, path, 2)
+	assert.True(t, strings.HasPrefix(path[1], dir))
+	saved, err := os.ReadFile(path[1])
+	require.NoError(t, err)
+	assert.Equal(t, content, string(saved))
+}
+
+func TestExecuteToolSpillsLargeOutput(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("uses POSIX shell commands")
+	}
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	t.Setenv("TMPDIR", t.TempDir())
+
+	input := []byte(`{"command": "echo start; for i in $(seq 1 2000); do echo line $i; done; echo end"}`)
+	result, err := executeTool(logger, nil, GenConfig{SpillToolOutput: 4096}, bashTool.Name, input)
+	require.NoError(t, err)
+	summary := result.Content.(string)
+	assert.Contains(t, summary, "too large to include in full")
+	assert.Contains(t, summary, "Start of the output:\nExit code: 0\nOutput: start\nline 1\n")
+	assert.True(t, strings.HasSuffix(summary, "line 2000\nend\n"))
+
+	result, err = executeTool(logger, nil, GenConfig{}, bashTool.Name, input)
+	require.NoError(t, err)
+	assert.NotContains(t, result.Content, "too large to include in full")
+}
@@ -0,0 +1,73 @@
+package agent
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/spachava753/cpe/internal/typeresolver"
+)
+
+var readSymbolTool = Tool{
+	Name: "read_symbol",
+	Description: `A tool to read the source of a single declaration, e.g. a function, method, type or constant, from a source file without reading the whole file
+* Supports Go, Java, Python, JSON and YAML files. The declarations of a JSON or YAML file are its mapping keys with their values
+* Returns the declaration's source with its line range
+* If several declarations share the name, e.g. methods of different types, all of them are returned`,
+	InputSchema: map[string]interface{}{
+		"type": "object",
+		"properties": map[string]interface{}{
+			"path": map[string]interface{}{
+				"type":        "string",
+				"description": `Relative path to the source file, e.g. "./internal/agent/tools.go"`,
+			},
+			"symbol": map[string]interface{}{
+				"type":        "string",
+				"description": "The name of the function, method, type, constant or variable to read.",
+			},
+		},
+		"required": []string{"path", "symbol"},
+	},
+}
+
+// symbolLanguages maps source file extensions to the language names understood by typeresolver.FindSymbol
+var symbolLanguages = map[string]string{
+	".go":   "go",
+	".java": "java",
+	".py":   "python",
+	".json": "json",
+	".yaml": "yaml",
+	".yml":  "yaml",
+}
+
+// executeReadSymbolTool validates and executes the read symbol tool, resolving the path against dir
+func executeReadSymbolTool(dir, path, symbol string) (*ToolResult, error) {
+	lang, ok := symbolLanguages[filepath.Ext(path)]
+	if !ok {
+		return &ToolResult{Content: fmt.Sprintf("Error reading symbol: unsupported file type %q, only Go, Java, Python, JSON and YAML files are supported", filepath.Ext(path)), IsError: true}, nil
+	}
+	content, err := os.ReadFile(resolvePath(dir, path))
+	if err != nil {
+		return &ToolResult{Content: fmt.Sprintf("Error reading file: %s", err), IsError: true}, nil
+	}
+
+	symbols, err := typeresolver.FindSymbol(lang, content, symbol)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find symbol %s in %s: %w", symbol, path, err)
+	}
+	if len(symbols) == 0 {
+		return &ToolResult{Content: fmt.Sprintf("Error reading symbol: no declaration of %s found in %s", symbol, path), IsError: true}, nil
+	}
+
+	var sb strings.Builder
+	if len(symbols) > 1 {
+		sb.WriteString(fmt.Sprintf("Found %d declarations of %s:\n\n", len(symbols), symbol))
+	}
+	for _, s := range symbols {
+		sb.WriteString(fmt.Sprintf("File: %s, lines %d-%d\nContent:\n```%s```\n\n", path, s.StartLine, s.EndLine, s.Source))
+	}
+	return &ToolResult{
+		Content: sb.String(),
+	}, nil
+}
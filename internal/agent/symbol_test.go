@@ -0,0 +1,56 @@
+package agent
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExecuteReadSymbolTool(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "math.go")
+	require.NoError(t, os.WriteFile(path, []byte(`package math
+
+func Add(a, b int) int {
+	return a + b
+}
+
+func Sub(a, b int) int {
+	return a - b
+}
+
+func Mul(a, b int) int {
+	return a * b
+}
+`), 0644))
+
+	result, err := executeReadSymbolTool(".", path, "Sub")
+	require.NoError(t, err)
+	assert.False(t, result.IsError)
+	assert.Equal(t, "File: "+path+", lines 7-9\nContent:\n```func Sub(a, b int) int {\n\treturn a - b\n}```\n\n", result.Content)
+
+	result, err = executeReadSymbolTool(".", path, "Div")
+	require.NoError(t, err)
+	assert.True(t, result.IsError)
+	assert.Contains(t, result.Content, "no declaration of Div found")
+
+	configPath := filepath.Join(dir, "config.json")
+	require.NoError(t, os.WriteFile(configPath, []byte("{\n  \"timeout\": 30\n}\n"), 0644))
+	result, err = executeReadSymbolTool(".", configPath, "timeout")
+	require.NoError(t, err)
+	assert.Equal(t, "File: "+configPath+", lines 2-2\nContent:\n```\"timeout\": 30```\n\n", result.Content)
+
+	workflowPath := filepath.Join(dir, "ci.yml")
+	require.NoError(t, os.WriteFile(workflowPath, []byte("name: ci\njobs:\n  test:\n    runs-on: ubuntu-latest\n"), 0644))
+	result, err = executeReadSymbolTool(".", workflowPath, "test")
+	require.NoError(t, err)
+	assert.Equal(t, "File: "+workflowPath+", lines 3-4\nContent:\n```test:\n    runs-on: ubuntu-latest```\n\n", result.Content)
+
+	result, err = executeReadSymbolTool(".", filepath.Join(dir, "notes.txt"), "Add")
+	require.NoError(t, err)
+	assert.True(t, result.IsError)
+	assert.Contains(t, result.Content, "unsupported file type")
+}
@@ -0,0 +1,98 @@
+package agent
+
+import (
+	"context"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	a "github.com/anthropics/anthropic-sdk-go"
+	"github.com/pkoukk/tiktoken-go"
+	"github.com/spachava753/cpe/internal/tiktokenloader"
+)
+
+// Tokenizer counts the tokens in a text as the model would, or estimates them when the model's tokenizer is
+// not available
+type Tokenizer interface {
+	CountTokens(text string) (int, error)
+}
+
+// o200kEncoding loads the tokenizer of OpenAI's recent models, which is bundled so it works offline
+var o200kEncoding = sync.OnceValues(func() (*tiktoken.Tiktoken, error) {
+	tiktoken.SetBpeLoader(tiktokenloader.NewOfflineLoader())
+	return tiktoken.GetEncoding("o200k_base")
+})
+
+// tiktokenTokenizer counts tokens with the o200k_base encoding used by OpenAI models. It is also the offline
+// approximation for other models, being much closer to their tokenizers than a character count. If the encoding
+// cannot be loaded, the tokens are estimated with heuristicTokenizer instead
+type tiktokenTokenizer struct{}
+
+func (tiktokenTokenizer) CountTokens(text string) (int, error) {
+	encoding, err := o200kEncoding()
+	if err != nil {
+		return heuristicTokenizer{}.CountTokens(text)
+	}
+	return len(encoding.Encode(text, nil, nil)), nil
+}
+
+// charsPerToken is the average number of characters per token assumed by the heuristic tokenizer
+const charsPerToken = 4
+
+// heuristicTokenizer estimates the tokens in a text from its number of characters, for when no tokenizer is
+// available
+type heuristicTokenizer struct{}
+
+func (heuristicTokenizer) CountTokens(text string) (int, error) {
+	return (utf8.RuneCountInString(text) + charsPerToken - 1) / charsPerToken, nil
+}
+
+// anthropicTokenizer counts tokens with Anthropic's count tokens API, as the input of a single user message.
+// When the API call fails, the tokens are approximated with tiktokenTokenizer instead
+type anthropicTokenizer struct {
+	client *a.Client
+	model  string
+	logger *slog.Logger
+}
+
+func (t anthropicTokenizer) CountTokens(text string) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	count, err := t.client.Beta.Messages.CountTokens(ctx, a.BetaMessageCountTokensParams{
+		Model: a.F(t.model),
+		Messages: a.F([]a.BetaMessageParam{
+			{
+				Role: a.F(a.BetaMessageParamRoleUser),
+				Content: a.F([]a.BetaContentBlockParamUnion{
+					a.BetaTextBlockParam{
+						Text: a.F(text),
+						Type: a.F(a.BetaTextBlockParamTypeText),
+					},
+				}),
+			},
+		}),
+	})
+	if err != nil {
+		t.logger.Warn("error counting tokens, estimating them instead", slog.Any("err", err))
+		return tiktokenTokenizer{}.CountTokens(text)
+	}
+	return int(count.InputTokens), nil
+}
+
+// selectTokenizer returns the tokenizer for model. Claude models are counted with Anthropic's count tokens API
+// when client is non-nil. Every other model, and Claude models without a client, are counted with tiktoken,
+// which is exact for OpenAI models and a close approximation for the others
+func selectTokenizer(model string, client *a.Client, logger *slog.Logger) Tokenizer {
+	if strings.HasPrefix(model, "claude") && client != nil {
+		return anthropicTokenizer{client: client, model: model, logger: logger}
+	}
+	return tiktokenTokenizer{}
+}
+
+// NewTokenizer returns the tokenizer used to size inputs for model without calling any API, e.g. to count the
+// tokens of many files at once
+func NewTokenizer(model string) Tokenizer {
+	return selectTokenizer(model, nil, nil)
+}
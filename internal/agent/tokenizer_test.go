@@ -0,0 +1,84 @@
+package agent
+
+import (
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	a "github.com/anthropics/anthropic-sdk-go"
+	"github.com/anthropics/anthropic-sdk-go/option"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHeuristicTokenizer(t *testing.T) {
+	tests := []struct {
+		text     string
+		expected int
+	}{
+		{text: "", expected: 0},
+		{text: "a", expected: 1},
+		{text: "abcd", expected: 1},
+		{text: "abcde", expected: 2},
+		{text: strings.Repeat("x", 400), expected: 100},
+		// characters are counted rather than bytes
+		{text: "héllo wörld", expected: 3},
+	}
+
+	for _, tt := range tests {
+		tokens, err := heuristicTokenizer{}.CountTokens(tt.text)
+		require.NoError(t, err)
+		assert.Equal(t, tt.expected, tokens, "text %q", tt.text)
+	}
+}
+
+func TestTiktokenTokenizer(t *testing.T) {
+	tokens, err := tiktokenTokenizer{}.CountTokens("hello world")
+	require.NoError(t, err)
+	assert.Equal(t, 2, tokens)
+}
+
+func TestSelectTokenizer(t *testing.T) {
+	client := a.NewClient()
+
+	tests := []struct {
+		model    string
+		client   *a.Client
+		expected Tokenizer
+	}{
+		{model: "claude-3-5-sonnet-20241022", client: client, expected: anthropicTokenizer{client: client, model: "claude-3-5-sonnet-20241022"}},
+		{model: "claude-3-5-sonnet-20241022", expected: tiktokenTokenizer{}},
+		{model: "gpt-4o", expected: tiktokenTokenizer{}},
+		{model: "o1-2024-12-17", expected: tiktokenTokenizer{}},
+		{model: "gemini-1.5-pro", expected: tiktokenTokenizer{}},
+		{model: "deepseek-chat", expected: tiktokenTokenizer{}},
+		{model: "my-gateway-model", expected: tiktokenTokenizer{}},
+	}
+
+	for _, tt := range tests {
+		assert.Equal(t, tt.expected, selectTokenizer(tt.model, tt.client, nil), "model %s", tt.model)
+	}
+
+	// -token-count sizes files for the default model without calling the count tokens API
+	assert.Equal(t, tiktokenTokenizer{}, NewTokenizer(DefaultModel))
+}
+
+func TestAnthropicTokenizerFallsBackToTiktoken(t *testing.T) {
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		http.Error(w, `{"type": "error", "error": {"type": "api_error", "message": "unavailable"}}`, http.StatusInternalServerError)
+	}))
+	t.Cleanup(server.Close)
+	client := a.NewClient(option.WithBaseURL(server.URL), option.WithAPIKey("test"), option.WithMaxRetries(0))
+	handler := &capturingHandler{level: slog.LevelWarn}
+
+	tokens, err := anthropicTokenizer{client: client, model: "claude-3-5-sonnet-20241022", logger: slog.New(handler)}.CountTokens("hello world")
+	require.NoError(t, err)
+	assert.Equal(t, 2, tokens)
+	assert.Equal(t, int32(1), requests.Load())
+	assert.True(t, handler.contains("error counting tokens, estimating them instead"))
+}
@@ -0,0 +1,47 @@
+package agent
+
+import "sync"
+
+// toolCache memoizes the results of the tools that scan the workspace (files_overview and get_related_files)
+// for the duration of a single call to Execute, so repeated identical calls do not rescan the tree.
+// Any mutating tool call invalidates the cache, since bash or file edits can change what a scan returns.
+type toolCache struct {
+	mu      sync.Mutex
+	results map[string]ToolResult
+}
+
+func newToolCache() *toolCache {
+	return &toolCache{results: make(map[string]ToolResult)}
+}
+
+// run returns the cached result of the identical call if there is one, and otherwise calls execute.
+// Successful results of scanning tools are cached, and mutating tools clear the cache.
+func (c *toolCache) run(call toolInvocation, execute func() (*ToolResult, error)) (*ToolResult, error) {
+	if isMutatingTool(call.name) {
+		c.mu.Lock()
+		clear(c.results)
+		c.mu.Unlock()
+		return execute()
+	}
+
+	cacheable := call.name == filesOverviewTool.Name || call.name == getRelatedFilesTool.Name
+	key := toolCallKey(call.name, call.input)
+	if cacheable {
+		c.mu.Lock()
+		cached, ok := c.results[key]
+		c.mu.Unlock()
+		if ok {
+			// return a copy, since callers annotate the result they are given
+			return &cached, nil
+		}
+	}
+
+	result, err := execute()
+	if err != nil || !cacheable || result.IsError {
+		return result, err
+	}
+	c.mu.Lock()
+	c.results[key] = *result
+	c.mu.Unlock()
+	return result, nil
+}
@@ -0,0 +1,74 @@
+package agent
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestToolCache(t *testing.T) {
+	cache := newToolCache()
+	scans := 0
+	scan := func() (*ToolResult, error) {
+		scans++
+		return &ToolResult{Content: "overview"}, nil
+	}
+	overview := toolInvocation{name: filesOverviewTool.Name, input: []byte(`{}`)}
+
+	result, err := cache.run(overview, scan)
+	require.NoError(t, err)
+	assert.Equal(t, "overview", result.Content)
+
+	// callers annotate the result they get, which must not leak into the cache
+	result.Content = "annotated"
+	result.ToolUseID = "toolu_1"
+
+	result, err = cache.run(overview, scan)
+	require.NoError(t, err)
+	assert.Equal(t, 1, scans, "an identical call should not rescan")
+	assert.Equal(t, &ToolResult{Content: "overview"}, result)
+
+	related := func(input string) toolInvocation {
+		return toolInvocation{name: getRelatedFilesTool.Name, input: []byte(input)}
+	}
+	_, err = cache.run(related(`{"input_files": ["main.go"]}`), scan)
+	require.NoError(t, err)
+	_, err = cache.run(related(`{"input_files":["main.go"]}`), scan)
+	require.NoError(t, err)
+	assert.Equal(t, 2, scans, "calls with equivalent arguments should share a cache entry")
+	_, err = cache.run(related(`{"input_files":["go.mod"]}`), scan)
+	require.NoError(t, err)
+	assert.Equal(t, 3, scans, "calls with different arguments should not share a cache entry")
+
+	edit := toolInvocation{name: fileEditor.Name, input: []byte(`{"command":"create","path":"new.go"}`)}
+	edits := 0
+	for range 2 {
+		_, err = cache.run(edit, func() (*ToolResult, error) {
+			edits++
+			return &ToolResult{Content: "Successfully created file new.go"}, nil
+		})
+		require.NoError(t, err)
+	}
+	assert.Equal(t, 2, edits, "mutating calls should never be served from the cache")
+
+	_, err = cache.run(overview, scan)
+	require.NoError(t, err)
+	assert.Equal(t, 4, scans, "an edit should invalidate the cache")
+}
+
+func TestToolCacheSkipsErrors(t *testing.T) {
+	cache := newToolCache()
+	scans := 0
+	failingScan := func() (*ToolResult, error) {
+		scans++
+		return &ToolResult{Content: "no such file", IsError: true}, nil
+	}
+	call := toolInvocation{name: getRelatedFilesTool.Name, input: []byte(`{"input_files":["missing.go"]}`)}
+
+	for range 2 {
+		_, err := cache.run(call, failingScan)
+		require.NoError(t, err)
+	}
+	assert.Equal(t, 2, scans, "error results should not be cached")
+}
@@ -0,0 +1,175 @@
+package agent
+
+import (
+	"fmt"
+	gitignore "github.com/sabhiram/go-gitignore"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+)
+
+// toolInvocation is a single tool invocation requested by the model in a turn
+type toolInvocation struct {
+	id    string
+	name  string
+	input []byte
+	// repeats is the number of consecutive identical calls, including this one, as counted by the toolCallTracker
+	repeats int
+}
+
+// executeTool runs the named tool with its raw JSON input. Results larger than config.SpillToolOutput are
+// written to a temporary file, see spillToolResult
+func executeTool(logger *slog.Logger, ignorer *gitignore.GitIgnore, config GenConfig, name string, input []byte) (*ToolResult, error) {
+	result, err := dispatchTool(logger, ignorer, config, name, input)
+	if err != nil || config.SpillToolOutput <= 0 {
+		return result, err
+	}
+	return spillToolResult(logger, name, result, config.SpillToolOutput, os.TempDir())
+}
+
+// dispatchTool runs the named tool with its raw JSON input. Calls to a tool that does not exist or is not offered
+// under config, e.g. a mutating tool in read-only mode, are returned as an error result so the model can recover
+func dispatchTool(logger *slog.Logger, ignorer *gitignore.GitIgnore, config GenConfig, name string, input []byte) (*ToolResult, error) {
+	if !toolEnabled(config, name) {
+		logger.Warn(fmt.Sprintf("model called the %s tool, which is not available", name))
+		return unavailableToolResult(config, name), nil
+	}
+	switch name {
+	case bashTool.Name:
+		var bashToolInput struct {
+			Command string `json:"command"`
+		}
+		if result := parseToolInput(bashTool, input, &bashToolInput); result != nil {
+			return result, nil
+		}
+		logger.Info(fmt.Sprintf("executing bash command: %s", bashToolInput.Command))
+		return executeBashTool(commandShell, toolDir(config), bashToolInput.Command)
+	case fileEditor.Name:
+		var fileEditorToolInput FileEditorParams
+		if result := parseToolInput(fileEditor, input, &fileEditorToolInput); result != nil {
+			return result, nil
+		}
+		logger.Info("executing file editor tool",
+			slog.String("command", fileEditorToolInput.Command),
+			slog.String("path", fileEditorToolInput.Path),
+		)
+		logger.Debug(fmt.Sprintf("old_str:\n%s\n\nnew_str:\n%s", fileEditorToolInput.OldStr, fileEditorToolInput.NewStr))
+		return executeFileEditorTool(toolDir(config), fileEditorToolInput)
+	case filesOverviewTool.Name:
+		var filesOverviewToolInput struct {
+			Full bool `json:"full"`
+		}
+		if result := parseToolInput(filesOverviewTool, input, &filesOverviewToolInput); result != nil {
+			return result, nil
+		}
+		logger.Info("executing files overview tool", slog.Bool("full", filesOverviewToolInput.Full))
+		return executeFilesOverviewTool(os.DirFS(toolDir(config)), ignorer, filesOverviewToolInput.Full, filesOverviewSummaryThreshold)
+	case getRelatedFilesTool.Name:
+		var relatedFilesToolInput struct {
+			InputFiles []string `json:"input_files"`
+		}
+		if result := parseToolInput(getRelatedFilesTool, input, &relatedFilesToolInput); result != nil {
+			return result, nil
+		}
+		logger.Info("getting related files", slog.Any("input_files", relatedFilesToolInput.InputFiles))
+		return executeGetRelatedFilesTool(toolDir(config), relatedFilesToolInput.InputFiles, ignorer)
+	case applyPatchTool.Name:
+		var applyPatchToolInput struct {
+			Patch string `json:"patch"`
+		}
+		if result := parseToolInput(applyPatchTool, input, &applyPatchToolInput); result != nil {
+			return result, nil
+		}
+		logger.Info("executing apply patch tool")
+		logger.Debug(fmt.Sprintf("patch:\n%s", applyPatchToolInput.Patch))
+		return applyPatch(toolDir(config), applyPatchToolInput.Patch)
+	case fetchURLTool.Name:
+		var fetchURLToolInput struct {
+			URL string `json:"url"`
+		}
+		if result := parseToolInput(fetchURLTool, input, &fetchURLToolInput); result != nil {
+			return result, nil
+		}
+		logger.Info("fetching url", slog.String("url", fetchURLToolInput.URL))
+		return executeFetchURLTool(fetchClient, fetchURLToolInput.URL, config.FetchDomains, maxFetchTextLength)
+	case readSymbolTool.Name:
+		var readSymbolToolInput struct {
+			Path   string `json:"path"`
+			Symbol string `json:"symbol"`
+		}
+		if result := parseToolInput(readSymbolTool, input, &readSymbolToolInput); result != nil {
+			return result, nil
+		}
+		logger.Info("reading symbol", slog.String("path", readSymbolToolInput.Path), slog.String("symbol", readSymbolToolInput.Symbol))
+		return executeReadSymbolTool(toolDir(config), readSymbolToolInput.Path, readSymbolToolInput.Symbol)
+	case gitDiffTool.Name:
+		var gitDiffToolInput struct {
+			Ref   string   `json:"ref"`
+			Paths []string `json:"paths"`
+		}
+		if result := parseToolInput(gitDiffTool, input, &gitDiffToolInput); result != nil {
+			return result, nil
+		}
+		logger.Info("getting git diff", slog.String("ref", gitDiffToolInput.Ref), slog.Any("paths", gitDiffToolInput.Paths))
+		return executeGitDiffTool(gitIn(toolDir(config)), gitDiffToolInput.Ref, gitDiffToolInput.Paths, maxGitDiffLength)
+	case runTestsTool.Name:
+		logger.Info(fmt.Sprintf("running tests: %s", config.TestCommand))
+		return runTests(toolDir(config), config.TestCommand, testTimeout)
+	default:
+		logger.Warn(fmt.Sprintf("model called the unknown tool %s", name))
+		return unavailableToolResult(config, name), nil
+	}
+}
+
+// unavailableToolResult returns the error result for a call to the named tool when it is not offered under config,
+// listing the tools that are
+func unavailableToolResult(config GenConfig, name string) *ToolResult {
+	var available []string
+	for _, tool := range allTools {
+		if toolEnabled(config, tool.Name) {
+			available = append(available, tool.Name)
+		}
+	}
+	return &ToolResult{
+		Content: fmt.Sprintf("Error: the %s tool is not available. Available tools: %s", name, strings.Join(available, ", ")),
+		IsError: true,
+	}
+}
+
+// runToolCalls executes the tool calls of a single turn with run and returns their results in the same order.
+// Consecutive read-only calls are run concurrently, at most limit at a time. Mutating calls are always run
+// one at a time, after every call before them has finished. A limit below 2 runs every call sequentially.
+func runToolCalls(calls []toolInvocation, limit int, run func(call toolInvocation) (*ToolResult, error)) ([]*ToolResult, error) {
+	results := make([]*ToolResult, len(calls))
+	for start := 0; start < len(calls); {
+		end := start + 1
+		if limit > 1 && !isMutatingTool(calls[start].name) {
+			for end < len(calls) && !isMutatingTool(calls[end].name) {
+				end++
+			}
+		}
+
+		errs := make([]error, end-start)
+		sem := make(chan struct{}, max(limit, 1))
+		var wg sync.WaitGroup
+		for i := start; i < end; i++ {
+			wg.Add(1)
+			sem <- struct{}{}
+			go func() {
+				defer wg.Done()
+				defer func() { <-sem }()
+				results[i], errs[i-start] = run(calls[i])
+			}()
+		}
+		wg.Wait()
+
+		for i, err := range errs {
+			if err != nil {
+				return nil, fmt.Errorf("failed to execute tool %s: %w", calls[start+i].name, err)
+			}
+		}
+		start = end
+	}
+	return results, nil
+}
@@ -0,0 +1,167 @@
+package agent
+
+import (
+	"errors"
+	"io"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	gitignore "github.com/sabhiram/go-gitignore"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// concurrencyRecorder runs fake tool calls, recording the order they started in and the peak number running at once
+type concurrencyRecorder struct {
+	running atomic.Int32
+	peak    atomic.Int32
+	mu      sync.Mutex
+	started []string
+}
+
+func (r *concurrencyRecorder) run(call toolInvocation) (*ToolResult, error) {
+	r.mu.Lock()
+	r.started = append(r.started, call.id)
+	r.mu.Unlock()
+
+	n := r.running.Add(1)
+	defer r.running.Add(-1)
+	for {
+		peak := r.peak.Load()
+		if n <= peak || r.peak.CompareAndSwap(peak, n) {
+			break
+		}
+	}
+	time.Sleep(20 * time.Millisecond)
+	return &ToolResult{Content: "result " + call.id}, nil
+}
+
+func TestRunToolCalls(t *testing.T) {
+	read := func(id string) toolInvocation { return toolInvocation{id: id, name: filesOverviewTool.Name} }
+	bash := func(id string) toolInvocation { return toolInvocation{id: id, name: bashTool.Name} }
+
+	tests := []struct {
+		name        string
+		calls       []toolInvocation
+		limit       int
+		wantPeak    int32
+		wantStarted []string
+		// wantStartedAt maps a position in the start order to the call that must start there
+		wantStartedAt map[int]string
+	}{
+		{
+			name:     "read-only calls run concurrently",
+			calls:    []toolInvocation{read("1"), read("2"), read("3"), read("4")},
+			limit:    4,
+			wantPeak: 4,
+		},
+		{
+			name:     "concurrency is capped at the limit",
+			calls:    []toolInvocation{read("1"), read("2"), read("3"), read("4"), read("5")},
+			limit:    2,
+			wantPeak: 2,
+		},
+		{
+			name:        "calls are sequential by default",
+			calls:       []toolInvocation{read("1"), read("2"), read("3")},
+			limit:       0,
+			wantPeak:    1,
+			wantStarted: []string{"1", "2", "3"},
+		},
+		{
+			name:        "mutating calls are serialized in order",
+			calls:       []toolInvocation{bash("1"), bash("2"), bash("3")},
+			limit:       4,
+			wantPeak:    1,
+			wantStarted: []string{"1", "2", "3"},
+		},
+		{
+			name:          "mutating calls wait for earlier read-only calls",
+			calls:         []toolInvocation{read("1"), read("2"), bash("3"), read("4"), read("5")},
+			limit:         4,
+			wantPeak:      2,
+			wantStartedAt: map[int]string{2: "3"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var recorder concurrencyRecorder
+			results, err := runToolCalls(tt.calls, tt.limit, recorder.run)
+			require.NoError(t, err)
+
+			require.Len(t, results, len(tt.calls))
+			for i, call := range tt.calls {
+				assert.Equal(t, "result "+call.id, results[i].Content)
+			}
+			assert.Equal(t, tt.wantPeak, recorder.peak.Load())
+			if tt.wantStarted != nil {
+				assert.Equal(t, tt.wantStarted, recorder.started)
+			}
+			for i, id := range tt.wantStartedAt {
+				assert.Equal(t, id, recorder.started[i])
+			}
+		})
+	}
+}
+
+func TestRunToolCallsError(t *testing.T) {
+	calls := []toolInvocation{
+		{id: "1", name: filesOverviewTool.Name},
+		{id: "2", name: getRelatedFilesTool.Name},
+		{id: "3", name: bashTool.Name},
+	}
+	var ran []string
+	var mu sync.Mutex
+	_, err := runToolCalls(calls, 2, func(call toolInvocation) (*ToolResult, error) {
+		mu.Lock()
+		ran = append(ran, call.id)
+		mu.Unlock()
+		if call.name == getRelatedFilesTool.Name {
+			return nil, errors.New("boom")
+		}
+		return &ToolResult{}, nil
+	})
+	assert.EqualError(t, err, "failed to execute tool get_related_files: boom")
+	assert.ElementsMatch(t, []string{"1", "2"}, ran, "the mutating call after a failure should not run")
+}
+
+func TestDispatchUnavailableTool(t *testing.T) {
+	tests := []struct {
+		name     string
+		tool     string
+		config   GenConfig
+		expected string
+	}{
+		{
+			name:     "unknown tool",
+			tool:     "str_replace_editor",
+			config:   GenConfig{},
+			expected: "Error: the str_replace_editor tool is not available. Available tools: bash, file_editor, files_overview, get_related_files, apply_patch, read_symbol, git_diff",
+		},
+		{
+			name:     "mutating tool in read-only mode",
+			tool:     bashTool.Name,
+			config:   GenConfig{ReadOnly: true},
+			expected: "Error: the bash tool is not available. Available tools: files_overview, get_related_files, read_symbol, git_diff",
+		},
+		{
+			name:     "disabled tool",
+			tool:     runTestsTool.Name,
+			config:   GenConfig{FetchDomains: []string{"go.dev"}},
+			expected: "Error: the run_tests tool is not available. Available tools: bash, file_editor, files_overview, get_related_files, apply_patch, fetch_url, read_symbol, git_diff",
+		},
+	}
+
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := dispatchTool(logger, gitignore.CompileIgnoreLines(), tt.config, tt.tool, []byte(`{"command": "rm -rf build"}`))
+			require.NoError(t, err)
+			assert.Equal(t, &ToolResult{Content: tt.expected, IsError: true}, result)
+		})
+	}
+}
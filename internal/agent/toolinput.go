@@ -0,0 +1,69 @@
+package agent
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+)
+
+// maxEchoedToolInput is the number of bytes of malformed tool input that is echoed back to the model
+const maxEchoedToolInput = 2000
+
+// parseToolInput unmarshals the raw JSON input of tool into v. Empty input is treated as an empty object.
+// Malformed input, e.g. JSON that was cut off when the response hit the max tokens limit, is returned as an
+// error result with the input that was received and the arguments the tool expects, so that the model can
+// correct the call instead of the agent aborting. The result is nil if the input was parsed
+func parseToolInput(tool Tool, input []byte, v any) *ToolResult {
+	if len(bytes.TrimSpace(input)) == 0 {
+		input = []byte("{}")
+	}
+	err := json.Unmarshal(input, v)
+	if err == nil {
+		return nil
+	}
+
+	received := string(input)
+	if len(received) > maxEchoedToolInput {
+		received = received[:maxEchoedToolInput] + "..."
+	}
+	return &ToolResult{
+		Content: fmt.Sprintf("Error: invalid arguments for the %s tool: %s\nReceived: %s\nExpected a JSON object of the form: %s",
+			tool.Name, err, received, toolInputShape(tool)),
+		IsError: true,
+	}
+}
+
+// toolInputShape describes the arguments in the input schema of tool, e.g. {"command": string (required)}
+func toolInputShape(tool Tool) string {
+	properties, _ := tool.InputSchema["properties"].(map[string]interface{})
+	required, _ := tool.InputSchema["required"].([]string)
+
+	var fields []string
+	for _, name := range slices.Sorted(maps.Keys(properties)) {
+		field := fmt.Sprintf("%q: %s", name, schemaType(properties[name]))
+		if slices.Contains(required, name) {
+			field += " (required)"
+		}
+		fields = append(fields, field)
+	}
+	return "{" + strings.Join(fields, ", ") + "}"
+}
+
+// schemaType returns the type of a JSON schema property, e.g. "string" or "array of string"
+func schemaType(property any) string {
+	schema, _ := property.(map[string]interface{})
+	typ, _ := schema["type"].(string)
+	if typ == "" {
+		return "any"
+	}
+	if typ == "array" {
+		return "array of " + schemaType(schema["items"])
+	}
+	if enum, ok := schema["enum"].([]string); ok {
+		return fmt.Sprintf("%s, one of %s", typ, strings.Join(enum, ", "))
+	}
+	return typ
+}
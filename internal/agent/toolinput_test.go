@@ -0,0 +1,125 @@
+package agent
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+	"testing"
+
+	gitignore "github.com/sabhiram/go-gitignore"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExecuteToolMalformedInput(t *testing.T) {
+	tests := []struct {
+		tool      Tool
+		input     string
+		wantShape string
+	}{
+		{
+			tool:      bashTool,
+			input:     `{"command": "ls -la`,
+			wantShape: `{"command": string (required)}`,
+		},
+		{
+			tool:      fileEditor,
+			input:     `{"command": "create", "path": 42}`,
+			wantShape: `{"command": string, one of create, str_replace, remove (required), "file_text": string, "new_str": string, "old_str": string, "path": string (required)}`,
+		},
+		{
+			tool:      getRelatedFilesTool,
+			input:     `{"input_files": "main.go"}`,
+			wantShape: `{"input_files": array of string (required)}`,
+		},
+		{
+			tool:      fetchURLTool,
+			input:     `not json`,
+			wantShape: `{"url": string (required)}`,
+		},
+		{
+			tool:      readSymbolTool,
+			input:     `{"path": "main.go", "symbol": `,
+			wantShape: `{"path": string (required), "symbol": string (required)}`,
+		},
+	}
+
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	for _, tt := range tests {
+		t.Run(tt.tool.Name, func(t *testing.T) {
+			result, err := executeTool(logger, gitignore.CompileIgnoreLines(), GenConfig{FetchDomains: []string{"example.com"}}, tt.tool.Name, []byte(tt.input))
+			require.NoError(t, err)
+			require.NotNil(t, result)
+			assert.True(t, result.IsError)
+
+			content, ok := result.Content.(string)
+			require.True(t, ok)
+			lines := strings.Split(content, "\n")
+			require.Len(t, lines, 3)
+			assert.True(t, strings.HasPrefix(lines[0], fmt.Sprintf("Error: invalid arguments for the %s tool: ", tt.tool.Name)), lines[0])
+			assert.Equal(t, "Received: "+tt.input, lines[1])
+			assert.Equal(t, "Expected a JSON object of the form: "+tt.wantShape, lines[2])
+		})
+	}
+}
+
+func TestParseToolInput(t *testing.T) {
+	var input struct {
+		Full bool `json:"full"`
+	}
+	assert.Nil(t, parseToolInput(filesOverviewTool, nil, &input))
+	assert.Nil(t, parseToolInput(filesOverviewTool, []byte(`{"full": true}`), &input))
+	assert.True(t, input.Full)
+
+	result := parseToolInput(filesOverviewTool, []byte(strings.Repeat("x", maxEchoedToolInput+100)), &input)
+	require.NotNil(t, result)
+	assert.Contains(t, result.Content, "Received: "+strings.Repeat("x", maxEchoedToolInput)+"...\n")
+}
+
+// openaiToolCallResponse returns a chat completion response that calls the named tool with the raw arguments
+func openaiToolCallResponse(name, arguments string) string {
+	encoded, _ := json.Marshal(arguments)
+	return fmt.Sprintf(`{
+	"id": "chatcmpl-1",
+	"object": "chat.completion",
+	"created": 1,
+	"model": "gpt-4o",
+	"choices": [{"index": 0, "finish_reason": "tool_calls", "message": {"role": "assistant", "content": null, "tool_calls": [{"id": "call_1", "type": "function", "function": {"name": %q, "arguments": %s}}]}}],
+	"usage": {"prompt_tokens": 1, "completion_tokens": 1, "total_tokens": 2}
+}`, name, encoded)
+}
+
+func TestOpenAIExecutorReportsMalformedToolInput(t *testing.T) {
+	var second struct {
+		Messages []struct {
+			Role    string `json:"role"`
+			Content []struct {
+				Text string `json:"text"`
+			} `json:"content"`
+		} `json:"messages"`
+	}
+	server := newStubServer(t, openaiToolCallResponse(bashTool.Name, `{"command": "ls`), openaiContentResponse("done"))
+
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	executor, err := NewOpenAIExecutor(server.URL, "test-key", nil, logger, gitignore.CompileIgnoreLines(), GenConfig{Model: "gpt-4o"})
+	require.NoError(t, err)
+	require.NoError(t, executor.Execute("list the files"))
+
+	requests := server.requests()
+	require.Len(t, requests, 2)
+	require.NoError(t, json.Unmarshal([]byte(requests[1]), &second))
+	last := second.Messages[len(second.Messages)-1]
+	assert.Equal(t, "tool", last.Role)
+	require.Len(t, last.Content, 1)
+	var toolResult struct {
+		Content string `json:"content"`
+		Error   bool   `json:"error"`
+	}
+	require.NoError(t, json.Unmarshal([]byte(last.Content[0].Text), &toolResult))
+	assert.True(t, toolResult.Error)
+	assert.Equal(t, "Error: invalid arguments for the bash tool: unexpected end of JSON input\n"+
+		`Received: {"command": "ls`+"\n"+
+		`Expected a JSON object of the form: {"command": string (required)}`, toolResult.Content)
+}
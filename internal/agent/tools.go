@@ -1,12 +1,18 @@
 package agent
 
 import (
+	"errors"
 	"fmt"
 	ignore "github.com/sabhiram/go-gitignore"
 	"github.com/spachava753/cpe/internal/codemap"
 	"github.com/spachava753/cpe/internal/typeresolver"
+	"io/fs"
+	"log/slog"
 	"os"
 	"os/exec"
+	"path"
+	"path/filepath"
+	"slices"
 	"sort"
 	"strings"
 )
@@ -18,21 +24,14 @@ type Tool struct {
 }
 
 var bashTool = Tool{
-	Name: "bash",
-	Description: `Run commands in a bash shell
-* When invoking this tool, the contents of the "command" parameter does NOT need to be escaped.
-* You can access the internet via this tool with CLI's like "curl" or "wget" command.
-* You can install the necessary dependencies for your project with this tool, e.g. "pip install", "npm install", "apt-get install", "brew install", etc.
-* State is persistent across command calls.
-* To inspect a particular line range of a file, e.g. lines 10-25, try 'sed -n 10,25p /path/to/the/file'.
-* Avoid commands that may produce a very large amount of output.
-* Run long lived commands in the background, e.g. 'sleep 10 &' or start a server in the background`,
+	Name:        "bash",
+	Description: bashToolDescription(commandShell),
 	InputSchema: map[string]interface{}{
 		"type": "object",
 		"properties": map[string]interface{}{
 			"command": map[string]interface{}{
 				"type":        "string",
-				"description": "The bash command to run.",
+				"description": "The command to run.",
 			},
 		},
 		"required": []string{"command"},
@@ -84,10 +83,16 @@ var filesOverviewTool = Tool{
 	Description: `A tool to get an overview of all of the files found recursively in the current directory 
 * Each file is recursively listed with its relative path from the current directory and the contents of the file.
 * The contents of the file may omit certain lines to reduce the number of lines returned. For example, for source code files, the function and method bodies are omitted.
-* The file can be of any type, as long as it contains only text`,
+* The file can be of any type, as long as it contains only text
+* When there are a lot of files, only a summary of each directory with its number of files and a few example files is returned. Set "full" to true to list every file regardless`,
 	InputSchema: map[string]interface{}{
-		"type":       "object",
-		"properties": map[string]interface{}{},
+		"type": "object",
+		"properties": map[string]interface{}{
+			"full": map[string]interface{}{
+				"type":        "boolean",
+				"description": "List every file with its contents even when there are a lot of files.",
+			},
+		},
 	},
 }
 
@@ -114,19 +119,74 @@ var getRelatedFilesTool = Tool{
 	},
 }
 
+// isMutatingTool reports whether the named tool can change the workspace. Mutating tools are not offered in read-only mode
+func isMutatingTool(name string) bool {
+	return name == bashTool.Name || name == fileEditor.Name || name == applyPatchTool.Name || name == runTestsTool.Name
+}
+
+// allTools lists every tool that can be offered to the model
+var allTools = []Tool{bashTool, fileEditor, filesOverviewTool, getRelatedFilesTool, applyPatchTool, fetchURLTool, runTestsTool, readSymbolTool, gitDiffTool}
+
+// toolDescription returns the description of the named tool that is advertised to the model, with the user's
+// override applied. An override starting with "+" is appended to the default description, any other replaces it
+func toolDescription(config GenConfig, name, description string) string {
+	override, ok := config.ToolDescriptions[name]
+	if !ok {
+		return description
+	}
+	if extra, ok := strings.CutPrefix(override, "+"); ok {
+		return description + "\n" + strings.TrimSpace(extra)
+	}
+	return override
+}
+
+// warnUnknownToolDescriptions logs a warning for every tool description override that does not name a tool
+func warnUnknownToolDescriptions(logger *slog.Logger, overrides map[string]string) {
+	for name := range overrides {
+		if !slices.ContainsFunc(allTools, func(tool Tool) bool { return tool.Name == name }) {
+			logger.Warn(fmt.Sprintf("ignoring the description override for unknown tool %q", name))
+		}
+	}
+}
+
+// toolEnabled reports whether the named tool is offered to the model under the given config
+func toolEnabled(config GenConfig, name string) bool {
+	if config.ReadOnly && isMutatingTool(name) {
+		return false
+	}
+	if name == fetchURLTool.Name && len(config.FetchDomains) == 0 {
+		return false
+	}
+	if name == runTestsTool.Name && config.TestCommand == "" {
+		return false
+	}
+	return true
+}
+
 type ToolResult struct {
 	ToolUseID string
 	Content   any
 	IsError   bool
 }
 
-// executeBashTool validates and executes the bash tool
-func executeBashTool(command string) (*ToolResult, error) {
-	cmd := exec.Command("bash", "-c", command)
+// executeBashTool validates and executes the bash tool, running command with the given shell in dir. The result
+// starts with the command's exit code and is an error result if the command failed
+func executeBashTool(shell, dir, command string) (*ToolResult, error) {
+	args := shellCommandArgs(shell, command)
+	cmd := exec.Command(args[0], args[1:]...)
 	cmd.Env = os.Environ()
+	cmd.Dir = dir
 
 	output, err := cmd.CombinedOutput()
-	if err != nil {
+	var exitErr *exec.ExitError
+	switch {
+	case errors.As(err, &exitErr) && exitErr.ExitCode() >= 0:
+		return &ToolResult{
+			Content: fmt.Sprintf("Error executing command: %s\nExit code: %d\nOutput: %s", err, exitErr.ExitCode(), string(output)),
+			IsError: true,
+		}, nil
+	case err != nil:
+		// the command did not start or was killed by a signal, so there is no exit code
 		return &ToolResult{
 			Content: fmt.Sprintf("Error executing command: %s\nOutput: %s", err, string(output)),
 			IsError: true,
@@ -134,7 +194,7 @@ func executeBashTool(command string) (*ToolResult, error) {
 	}
 
 	return &ToolResult{
-		Content: string(output),
+		Content: fmt.Sprintf("Exit code: 0\nOutput: %s", string(output)),
 	}, nil
 }
 
@@ -147,8 +207,9 @@ type FileEditorParams struct {
 	NewStr   string `json:"new_str,omitempty"`
 }
 
-// executeFileEditorTool validates and executes the file editor tool
-func executeFileEditorTool(params FileEditorParams) (*ToolResult, error) {
+// executeFileEditorTool validates and executes the file editor tool, resolving the path against dir
+func executeFileEditorTool(dir string, params FileEditorParams) (*ToolResult, error) {
+	filename := resolvePath(dir, params.Path)
 
 	switch params.Command {
 	case "create":
@@ -158,7 +219,7 @@ func executeFileEditorTool(params FileEditorParams) (*ToolResult, error) {
 				IsError: true,
 			}, nil
 		}
-		if err := os.WriteFile(params.Path, []byte(params.FileText), 0644); err != nil {
+		if err := os.WriteFile(filename, []byte(params.FileText), 0644); err != nil {
 			return &ToolResult{
 				Content: fmt.Sprintf("Error creating file: %s", err),
 				IsError: true,
@@ -169,7 +230,7 @@ func executeFileEditorTool(params FileEditorParams) (*ToolResult, error) {
 		}, nil
 
 	case "str_replace":
-		content, err := os.ReadFile(params.Path)
+		content, err := os.ReadFile(filename)
 		if err != nil {
 			return &ToolResult{
 				Content: fmt.Sprintf("Error reading file: %s", err),
@@ -185,7 +246,7 @@ func executeFileEditorTool(params FileEditorParams) (*ToolResult, error) {
 		}
 
 		newContent := strings.Replace(string(content), params.OldStr, params.NewStr, 1)
-		if err := os.WriteFile(params.Path, []byte(newContent), 0644); err != nil {
+		if err := os.WriteFile(filename, []byte(newContent), 0644); err != nil {
 			return &ToolResult{
 				Content: fmt.Sprintf("Error writing file: %s", err),
 				IsError: true,
@@ -196,7 +257,7 @@ func executeFileEditorTool(params FileEditorParams) (*ToolResult, error) {
 		}, nil
 
 	case "remove":
-		if err := os.Remove(params.Path); err != nil {
+		if err := os.Remove(filename); err != nil {
 			return &ToolResult{
 				Content: fmt.Sprintf("Error removing file: %s", err),
 				IsError: true,
@@ -214,10 +275,27 @@ func executeFileEditorTool(params FileEditorParams) (*ToolResult, error) {
 	}
 }
 
-// executeFilesOverviewTool validates and executes the files overview tool
-func executeFilesOverviewTool(ignorer *ignore.GitIgnore) (*ToolResult, error) {
-	fsys := os.DirFS(".")
-	files, err := codemap.GenerateOutput(fsys, 100, ignorer)
+// filesOverviewSummaryThreshold is the number of files above which the files overview tool summarizes the files
+// by directory instead of listing them, unless the full listing is requested
+const filesOverviewSummaryThreshold = 200
+
+// exampleFilesPerDirectory is the number of file names listed for each directory in a files overview summary
+const exampleFilesPerDirectory = 3
+
+// executeFilesOverviewTool validates and executes the files overview tool. Above threshold files, only a summary
+// of each directory is returned unless full is set
+func executeFilesOverviewTool(fsys fs.FS, ignorer *ignore.GitIgnore, full bool, threshold int) (*ToolResult, error) {
+	paths, err := codemap.ListFiles(fsys, ignorer)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list files: %w", err)
+	}
+	if !full && len(paths) > threshold {
+		return &ToolResult{
+			Content: summarizeFiles(paths),
+		}, nil
+	}
+
+	files, err := codemap.GenerateFilesOutput(fsys, paths, 100)
 	if err != nil {
 		return nil, fmt.Errorf("failed to generate code map: %w", err)
 	}
@@ -232,10 +310,37 @@ func executeFilesOverviewTool(ignorer *ignore.GitIgnore) (*ToolResult, error) {
 	}, nil
 }
 
-// executeGetRelatedFilesTool validates and executes the get related files tool
-func executeGetRelatedFilesTool(inputFiles []string, ignorer *ignore.GitIgnore) (*ToolResult, error) {
+// summarizeFiles groups file paths by directory, listing the number of files in each directory and a few of their names
+func summarizeFiles(paths []string) string {
+	byDir := make(map[string][]string)
+	var dirs []string
+	for _, p := range paths {
+		dir := path.Dir(p)
+		if _, ok := byDir[dir]; !ok {
+			dirs = append(dirs, dir)
+		}
+		byDir[dir] = append(byDir[dir], path.Base(p))
+	}
+	sort.Strings(dirs)
+
+	var sb strings.Builder
+	sb.WriteString(fmt.Sprintf("There are %d files in %d directories, too many to list. Files by directory:\n", len(paths), len(dirs)))
+	for _, dir := range dirs {
+		names := byDir[dir]
+		examples := strings.Join(names[:min(len(names), exampleFilesPerDirectory)], ", ")
+		if len(names) > exampleFilesPerDirectory {
+			examples += ", ..."
+		}
+		sb.WriteString(fmt.Sprintf("- %s (%d files): %s\n", dir, len(names), examples))
+	}
+	sb.WriteString("\nCall this tool again with \"full\" set to true to list every file with its contents, or use the \"get_related_files\" tool to read specific files.\n")
+	return sb.String()
+}
+
+// executeGetRelatedFilesTool validates and executes the get related files tool for the files under dir
+func executeGetRelatedFilesTool(dir string, inputFiles []string, ignorer *ignore.GitIgnore) (*ToolResult, error) {
 
-	relatedFiles, err := typeresolver.ResolveTypeAndFunctionFiles(inputFiles, os.DirFS("."), ignorer)
+	relatedFiles, err := typeresolver.ResolveTypeAndFunctionFiles(inputFiles, os.DirFS(dir), ignorer)
 	if err != nil {
 		return nil, fmt.Errorf("failed to resolve related files: %w", err)
 	}
@@ -249,7 +354,7 @@ func executeGetRelatedFilesTool(inputFiles []string, ignorer *ignore.GitIgnore)
 
 	var sb strings.Builder
 	for _, file := range files {
-		content, err := os.ReadFile(file)
+		content, err := os.ReadFile(filepath.Join(dir, file))
 		if err != nil {
 			return nil, fmt.Errorf("failed to read file %s: %w", file, err)
 		}
@@ -0,0 +1,100 @@
+package agent
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"testing"
+	"testing/fstest"
+
+	a "github.com/anthropics/anthropic-sdk-go"
+	gitignore "github.com/sabhiram/go-gitignore"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestToolDescriptionOverrides(t *testing.T) {
+	var req struct {
+		Tools []struct {
+			Name        string `json:"name"`
+			Description string `json:"description"`
+		} `json:"tools"`
+	}
+	server := newStubServer(t, textResponse)
+
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	executor, err := NewAnthropicExecutor(server.URL, "test-key", nil, logger, gitignore.CompileIgnoreLines(), GenConfig{
+		Model:     a.ModelClaude3_5Sonnet20241022,
+		MaxTokens: 1024,
+		ToolDescriptions: map[string]string{
+			bashTool.Name:   "+Run the tests with make test.",
+			fileEditor.Name: "Edit files. Never touch generated code.",
+		},
+	})
+	require.NoError(t, err)
+	require.NoError(t, executor.Execute("run the tests"))
+	requests := server.requests()
+	require.Len(t, requests, 1)
+	require.NoError(t, json.Unmarshal([]byte(requests[0]), &req))
+
+	descriptions := map[string]string{}
+	for _, tool := range req.Tools {
+		descriptions[tool.Name] = tool.Description
+	}
+	assert.Equal(t, bashTool.Description+"\nRun the tests with make test.", descriptions[bashTool.Name])
+	assert.Equal(t, "Edit files. Never touch generated code.", descriptions[fileEditor.Name])
+	assert.Equal(t, filesOverviewTool.Description, descriptions[filesOverviewTool.Name])
+}
+
+func TestWarnUnknownToolDescriptions(t *testing.T) {
+	handler := &capturingHandler{level: slog.LevelWarn}
+	warnUnknownToolDescriptions(slog.New(handler), map[string]string{
+		bashTool.Name: "+Prefer make targets.",
+		"edit_file":   "Edit files.",
+	})
+
+	assert.True(t, handler.contains(`ignoring the description override for unknown tool "edit_file"`))
+	assert.False(t, handler.contains(bashTool.Name))
+}
+
+func TestExecuteFilesOverviewToolSummary(t *testing.T) {
+	fsys := fstest.MapFS{}
+	for dir := 0; dir < 25; dir++ {
+		for file := 0; file < 20; file++ {
+			fsys[fmt.Sprintf("pkg%02d/file%02d.txt", dir, file)] = &fstest.MapFile{
+				Data: []byte(fmt.Sprintf("contents of file %d in directory %d\n", file, dir)),
+			}
+		}
+	}
+	fsys["vendor/lib/lib.txt"] = &fstest.MapFile{Data: []byte("vendored\n")}
+	ignorer := gitignore.CompileIgnoreLines("vendor/")
+
+	full, err := executeFilesOverviewTool(fsys, ignorer, true, 100)
+	require.NoError(t, err)
+	summary, err := executeFilesOverviewTool(fsys, ignorer, false, 100)
+	require.NoError(t, err)
+
+	fullContent, summaryContent := full.Content.(string), summary.Content.(string)
+	assert.Contains(t, fullContent, "File: pkg03/file07.txt\nContent:\n```contents of file 7 in directory 3\n```")
+	assert.Contains(t, summaryContent, "There are 500 files in 25 directories")
+	assert.Contains(t, summaryContent, "- pkg03 (20 files): file00.txt, file01.txt, file02.txt, ...\n")
+	assert.NotContains(t, summaryContent, "contents of file")
+	assert.NotContains(t, summaryContent, "vendor")
+	assert.Less(t, len(summaryContent)*5, len(fullContent), "summary should be much smaller than the full listing")
+
+	belowThreshold, err := executeFilesOverviewTool(fsys, ignorer, false, 1000)
+	require.NoError(t, err)
+	assert.Equal(t, fullContent, belowThreshold.Content)
+}
+
+func TestSummarizeFiles(t *testing.T) {
+	summary := summarizeFiles([]string{"a/w.txt", "a/x.txt", "a/y.txt", "a/z.txt", "b/c.txt", "main.go"})
+	assert.Equal(t, `There are 6 files in 3 directories, too many to list. Files by directory:
+- . (1 files): main.go
+- a (4 files): w.txt, x.txt, y.txt, ...
+- b (1 files): c.txt
+
+Call this tool again with "full" set to true to list every file with its contents, or use the "get_related_files" tool to read specific files.
+`, summary)
+}
@@ -0,0 +1,35 @@
+package agent
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// toolDir returns the directory the tools operate in
+func toolDir(config GenConfig) string {
+	if config.WorkDir == "" {
+		return "."
+	}
+	return config.WorkDir
+}
+
+// resolvePath resolves a path given by the model against dir. Absolute paths are returned unchanged
+func resolvePath(dir, path string) string {
+	if filepath.IsAbs(path) {
+		return path
+	}
+	return filepath.Join(dir, path)
+}
+
+// validateWorkDir returns an error if dir is not an existing directory
+func validateWorkDir(dir string) error {
+	info, err := os.Stat(dir)
+	if err != nil {
+		return fmt.Errorf("invalid working directory: %w", err)
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("invalid working directory: %s is not a directory", dir)
+	}
+	return nil
+}
@@ -0,0 +1,73 @@
+package agent
+
+import (
+	"encoding/json"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/spachava753/cpe/internal/ignore"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestToolsUseWorkDir(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "main.go"), []byte("package main\n\nfunc main() {}\n"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "secret.txt"), []byte("do not read"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, ".cpeignore"), []byte("secret.txt\n"), 0644))
+
+	ignorer, err := ignore.LoadIgnoreFiles(dir)
+	require.NoError(t, err)
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	config := GenConfig{WorkDir: dir}
+	run := func(name string, input any) *ToolResult {
+		t.Helper()
+		raw, err := json.Marshal(input)
+		require.NoError(t, err)
+		result, err := executeTool(logger, ignorer, config, name, raw)
+		require.NoError(t, err)
+		require.False(t, result.IsError, "%v", result.Content)
+		return result
+	}
+
+	run(fileEditor.Name, FileEditorParams{Command: "create", Path: "notes.txt", FileText: "hello"})
+	content, err := os.ReadFile(filepath.Join(dir, "notes.txt"))
+	require.NoError(t, err)
+	assert.Equal(t, "hello", string(content))
+	assert.NoFileExists(t, "notes.txt", "the file must not be created in the process's working directory")
+
+	result := run(bashTool.Name, map[string]string{"command": "cat notes.txt"})
+	assert.Equal(t, "Exit code: 0\nOutput: hello", result.Content)
+
+	result = run(filesOverviewTool.Name, map[string]bool{})
+	assert.Contains(t, result.Content, "File: main.go")
+	assert.Contains(t, result.Content, "File: notes.txt")
+	assert.NotContains(t, result.Content, "File: secret.txt", "files ignored by the workdir's .cpeignore must be skipped")
+	assert.NotContains(t, result.Content, "do not read")
+
+	result = run(readSymbolTool.Name, map[string]string{"path": "main.go", "symbol": "main"})
+	assert.Contains(t, result.Content, "func main() {}")
+}
+
+func TestInitExecutorInvalidWorkDir(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	dir := t.TempDir()
+
+	_, err := InitExecutor(logger, ModelOptions{Model: "gpt-4o", WorkDir: filepath.Join(dir, "missing")})
+	assert.ErrorContains(t, err, "invalid working directory")
+
+	file := filepath.Join(dir, "file.txt")
+	require.NoError(t, os.WriteFile(file, nil, 0644))
+	_, err = InitExecutor(logger, ModelOptions{Model: "gpt-4o", WorkDir: file})
+	assert.ErrorContains(t, err, "is not a directory")
+}
+
+func TestResolvePath(t *testing.T) {
+	assert.Equal(t, filepath.Join("work", "main.go"), resolvePath("work", "./main.go"))
+	assert.Equal(t, "main.go", resolvePath(".", "main.go"))
+	abs := filepath.Join(string(filepath.Separator), "tmp", "main.go")
+	assert.Equal(t, abs, resolvePath("work", abs))
+}
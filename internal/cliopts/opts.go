@@ -19,16 +19,98 @@ type Options struct {
 	FrequencyPenalty  float64
 	PresencePenalty   float64
 	NumberOfResponses int
+	MaxIterations     int
+	MaxToolRepeats    int
+	MaxParallelTools  int
+	FetchDomains      []string
+	ToolDescriptions  map[string]string
+	TestCommand       string
+	ResponseFormat    string
+	ResponseSchema    string
+	MaxInputTokens    int
+	GitContext        bool
+	ContextCommand    string
+	SpillToolOutput   int
+	Headers           map[string]string
+	WorkDir           string
 	Input             string
 	Version           bool
 	TokenCountPath    string
 	Prompt            string
+	Files             []string
+	Interactive       bool
+	Plan              bool
+	OutputSeparator   string
 }
 
 var Opts Options
 
+// stringSliceFlag is a flag that can be repeated, where each value may also be a comma separated list
+type stringSliceFlag []string
+
+func (s *stringSliceFlag) String() string {
+	return strings.Join(*s, ",")
+}
+
+func (s *stringSliceFlag) Set(value string) error {
+	for _, v := range strings.Split(value, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			*s = append(*s, v)
+		}
+	}
+	return nil
+}
+
+// toolDescriptionFlag is a repeatable flag of "name=description" pairs that override tool descriptions
+type toolDescriptionFlag map[string]string
+
+func (f *toolDescriptionFlag) String() string {
+	pairs := make([]string, 0, len(*f))
+	for name, description := range *f {
+		pairs = append(pairs, name+"="+description)
+	}
+	slices.Sort(pairs)
+	return strings.Join(pairs, ",")
+}
+
+func (f *toolDescriptionFlag) Set(value string) error {
+	name, description, ok := strings.Cut(value, "=")
+	if !ok || strings.TrimSpace(name) == "" {
+		return fmt.Errorf("expected name=description, got %q", value)
+	}
+	if *f == nil {
+		*f = make(toolDescriptionFlag)
+	}
+	(*f)[strings.TrimSpace(name)] = description
+	return nil
+}
+
+// headerFlag is a repeatable flag of "Name: value" HTTP headers
+type headerFlag map[string]string
+
+func (f *headerFlag) String() string {
+	headers := make([]string, 0, len(*f))
+	for name, value := range *f {
+		headers = append(headers, name+": "+value)
+	}
+	slices.Sort(headers)
+	return strings.Join(headers, ",")
+}
+
+func (f *headerFlag) Set(value string) error {
+	name, headerValue, ok := strings.Cut(value, ":")
+	if !ok || strings.TrimSpace(name) == "" {
+		return fmt.Errorf("expected \"Name: value\", got %q", value)
+	}
+	if *f == nil {
+		*f = make(headerFlag)
+	}
+	(*f)[strings.TrimSpace(name)] = strings.TrimSpace(headerValue)
+	return nil
+}
+
 func init() {
-	flag.StringVar(&Opts.TokenCountPath, "token-count", "", "Print a tree of directories and files with their token counts for the given path")
+	flag.StringVar(&Opts.TokenCountPath, "token-count", "", "Print a tree of directories and files with their token counts for the given path, counted offline with tiktoken")
 	flag.BoolVar(&Opts.Version, "version", false, "Print the version number and exit")
 	flag.StringVar(&Opts.Model, "model", agent.DefaultModel, fmt.Sprintf("Specify the model to use. Supported models: %s", strings.Join(slices.Collect(maps.Keys(agent.ModelConfigs)), ", ")))
 	flag.StringVar(&Opts.CustomURL, "custom-url", "", "Specify a custom base URL for the model provider API")
@@ -39,7 +121,26 @@ func init() {
 	flag.Float64Var(&Opts.FrequencyPenalty, "frequency-penalty", 0, "Frequency penalty (-2.0 - 2.0)")
 	flag.Float64Var(&Opts.PresencePenalty, "presence-penalty", 0, "Presence penalty (-2.0 - 2.0)")
 	flag.IntVar(&Opts.NumberOfResponses, "number-of-responses", 0, "Number of responses to generate")
-	flag.StringVar(&Opts.Input, "input", "", "Specify the input file path. Use '-' for stdin. If omitted, only command line arguments are used as input")
+	flag.IntVar(&Opts.MaxIterations, "max-iterations", 0, "Maximum number of tool use turns before the agent stops with an error (default 50). 0 uses the default, a negative value means no limit")
+	flag.IntVar(&Opts.MaxToolRepeats, "max-tool-repeats", 0, "Number of consecutive identical tool calls before the model is warned it is repeating itself; repeating once more stops the agent (default 3)")
+	flag.IntVar(&Opts.MaxParallelTools, "max-parallel-tools", 0, "Maximum number of read-only tool calls from a single turn to run concurrently. Tools that can modify files always run one at a time (default 1)")
+	flag.Var((*stringSliceFlag)(&Opts.FetchDomains), "fetch-domains", "Allow the agent to fetch web pages from these domains and their subdomains. Can be repeated or comma separated")
+	flag.Var((*toolDescriptionFlag)(&Opts.ToolDescriptions), "tool-description", "Override the description of a tool advertised to the model, as name=description. Start the description with + to append to the default instead, e.g. bash=+Run tests with make test. Can be repeated")
+	flag.StringVar(&Opts.TestCommand, "test-command", "", "Command the agent's run_tests tool runs to test the project (default \"go test -json ./...\" when a go.mod is present)")
+	flag.StringVar(&Opts.ResponseFormat, "response-format", "", "Format of the model's final response: text, json_object or json_schema. JSON formats are only supported by OpenAI and DeepSeek (json_object only) models (default text)")
+	flag.StringVar(&Opts.ResponseSchema, "response-schema", "", "Path to a JSON schema file the model's final response must follow. Implies -response-format json_schema")
+	flag.IntVar(&Opts.MaxInputTokens, "max-input-tokens", 0, "Largest request, in estimated tokens, sent to the model before failing with an error. The request includes the system prompt and the conversation so far (default the model's context window minus the max tokens, no limit for unknown models)")
+	flag.BoolVar(&Opts.GitContext, "git-context", false, "Tell the model the current git branch, uncommitted changes and recent commits. Ignored outside of a git repository")
+	flag.StringVar(&Opts.ContextCommand, "context-command", "", "Run this command when the agent starts and tell the model its output, e.g. \"make context\" printing architecture notes. The output is truncated to 10000 bytes, and a failing command is skipped with a warning")
+	flag.IntVar(&Opts.SpillToolOutput, "spill-tool-output", 0, "Write tool results larger than this many bytes to a temporary file, and only pass its path and the start and end of the output to the model (default 0, disabled)")
+	flag.Var((*headerFlag)(&Opts.Headers), "header", "Send an extra HTTP header, as \"Name: value\", with every request to Anthropic, OpenAI and DeepSeek models, e.g. for gateways that route on custom headers. Authentication headers cannot be overridden. Can be repeated")
+	flag.StringVar(&Opts.WorkDir, "workdir", "", "Directory the agent works in, instead of the current directory. Tools, .cpeignore files and the git context use this directory")
+	flag.StringVar(&Opts.WorkDir, "C", "", "Shorthand for -workdir")
+	flag.Var((*stringSliceFlag)(&Opts.Files), "files", "Attach the contents of files to the prompt. Accepts glob patterns and directories, relative to -workdir, and can be repeated or comma separated. Files ignored by .cpeignore or .gitignore are skipped")
+	flag.BoolVar(&Opts.Interactive, "interactive", false, "Start an interactive session that keeps the conversation going across messages. Type /help for commands")
+	flag.StringVar(&Opts.OutputSeparator, "output-separator", "newline", "Separator written between the model's final responses when stdout is not a terminal: newline, nul or a literal string. Nothing is written after the last response")
+	flag.BoolVar(&Opts.Plan, "plan", false, "Plan first with read-only tools, then ask for approval before executing the plan")
+	flag.StringVar(&Opts.Input, "input", "", "Specify the input file path. Use '-' for stdin. If omitted, stdin is read only when it is a pipe or a redirected file, along with the command line arguments")
 }
 
 func ParseFlags() {
@@ -17,8 +17,8 @@ type FileCodeMap struct {
 	Content string
 }
 
-// GenerateOutput creates the code map output for each file using AST
-func GenerateOutput(fsys fs.FS, maxLiteralLen int, ignorer *gitignore.GitIgnore) ([]FileCodeMap, error) {
+// ListFiles returns the sorted paths of the text files in fsys that are not ignored
+func ListFiles(fsys fs.FS, ignorer *gitignore.GitIgnore) ([]string, error) {
 	var filePaths []string
 	err := fs.WalkDir(fsys, ".", func(path string, d fs.DirEntry, err error) error {
 		if err != nil {
@@ -48,7 +48,20 @@ func GenerateOutput(fsys fs.FS, maxLiteralLen int, ignorer *gitignore.GitIgnore)
 	}
 
 	sort.Strings(filePaths)
+	return filePaths, nil
+}
+
+// GenerateOutput creates the code map output for each file using AST
+func GenerateOutput(fsys fs.FS, maxLiteralLen int, ignorer *gitignore.GitIgnore) ([]FileCodeMap, error) {
+	filePaths, err := ListFiles(fsys, ignorer)
+	if err != nil {
+		return nil, err
+	}
+	return GenerateFilesOutput(fsys, filePaths, maxLiteralLen)
+}
 
+// GenerateFilesOutput creates the code map output for the given files, e.g. as listed by ListFiles
+func GenerateFilesOutput(fsys fs.FS, filePaths []string, maxLiteralLen int) ([]FileCodeMap, error) {
 	var results []FileCodeMap
 	for _, path := range filePaths {
 		fileContent, err := generateFileOutput(fsys, path, maxLiteralLen)
@@ -12,9 +12,20 @@ var DefaultPatterns = []string{
 	".git/**",
 }
 
+// LoadIgnoreFiles compiles DefaultPatterns together with the patterns of every .cpeignore file from startDir up to the root
 func LoadIgnoreFiles(startDir string) (*gitignore.GitIgnore, error) {
-	ignoreFiles := findIgnoreFiles(startDir)
+	return compileIgnoreFiles(findIgnoreFiles(startDir))
+}
+
+// LoadIgnoreFilesWithGitignore is like LoadIgnoreFiles, but also compiles the patterns of every .gitignore file from
+// startDir up to the root. It is used where files that git ignores should be left out as well, e.g. when attaching
+// files to the prompt
+func LoadIgnoreFilesWithGitignore(startDir string) (*gitignore.GitIgnore, error) {
+	return compileIgnoreFiles(append(findFiles(startDir, ".gitignore"), findIgnoreFiles(startDir)...))
+}
 
+// compileIgnoreFiles compiles DefaultPatterns together with the patterns of the given ignore files
+func compileIgnoreFiles(ignoreFiles []string) (*gitignore.GitIgnore, error) {
 	var allPatterns []string
 	// Add default patterns first
 	allPatterns = append(allPatterns, DefaultPatterns...)
@@ -34,17 +45,23 @@ func LoadIgnoreFiles(startDir string) (*gitignore.GitIgnore, error) {
 	return gitignore.CompileIgnoreLines(allPatterns...), nil
 }
 
-// findIgnoreFiles finds all .cpeignore files in the directory hierarchy
+// findIgnoreFiles finds all .cpeignore files in the directory hierarchy. .gitignore files are deliberately not read,
+// so that files tracked by git can still be hidden from CPE
 func findIgnoreFiles(startDir string) []string {
-	var ignoreFiles []string
+	return findFiles(startDir, ".cpeignore")
+}
+
+// findFiles finds the files with the given name in startDir and each of its parent directories
+func findFiles(startDir, name string) []string {
+	var files []string
 	dir, err := filepath.Abs(startDir)
 	if err != nil {
 		panic("Could not find absolute start dir: " + startDir)
 	}
 	for {
-		ignoreFile := filepath.Join(dir, ".cpeignore")
-		if _, err := os.Stat(ignoreFile); err == nil {
-			ignoreFiles = append(ignoreFiles, ignoreFile)
+		file := filepath.Join(dir, name)
+		if _, err := os.Stat(file); err == nil {
+			files = append(files, file)
 		}
 		parent := filepath.Dir(dir)
 		if parent == dir {
@@ -52,5 +69,5 @@ func findIgnoreFiles(startDir string) []string {
 		}
 		dir = parent
 	}
-	return ignoreFiles
+	return files
 }
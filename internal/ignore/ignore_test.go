@@ -138,3 +138,80 @@ func TestFindIgnoreFiles(t *testing.T) {
 		})
 	}
 }
+
+func TestLoadIgnoreFilesIndependentOfGitignore(t *testing.T) {
+	tempDir := t.TempDir()
+
+	// vendored code is committed, so it is not in .gitignore, but should still be hidden from CPE
+	files := map[string]string{
+		filepath.Join(tempDir, ".gitignore"): `bin/
+!vendor/`,
+		filepath.Join(tempDir, ".cpeignore"): `vendor/`,
+	}
+	for path, content := range files {
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to create %s: %v", path, err)
+		}
+	}
+
+	ignorer, err := LoadIgnoreFiles(tempDir)
+	if err != nil {
+		t.Fatalf("LoadIgnoreFiles failed: %v", err)
+	}
+
+	testCases := []struct {
+		path     string
+		expected bool
+	}{
+		{"vendor/github.com/dep/dep.go", true},
+		{"bin/cpe", false}, // only .cpeignore patterns apply
+		{"main.go", false},
+	}
+
+	for _, tc := range testCases {
+		if ignorer.MatchesPath(tc.path) != tc.expected {
+			t.Errorf("MatchesPath(%q) = %v, want %v", tc.path, !tc.expected, tc.expected)
+		}
+	}
+}
+
+func TestLoadIgnoreFilesWithGitignore(t *testing.T) {
+	parent := t.TempDir()
+	repo := filepath.Join(parent, "repo")
+	if err := os.MkdirAll(repo, 0755); err != nil {
+		t.Fatalf("Failed to create repo dir: %v", err)
+	}
+
+	files := map[string]string{
+		filepath.Join(parent, ".gitignore"): "*.tmp",
+		filepath.Join(repo, ".gitignore"):   "bin/\n.env",
+		filepath.Join(repo, ".cpeignore"):   "vendor/",
+	}
+	for path, content := range files {
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to create %s: %v", path, err)
+		}
+	}
+
+	ignorer, err := LoadIgnoreFilesWithGitignore(repo)
+	if err != nil {
+		t.Fatalf("LoadIgnoreFilesWithGitignore failed: %v", err)
+	}
+
+	testCases := []struct {
+		path     string
+		expected bool
+	}{
+		{"bin/cpe", true},
+		{".env", true},
+		{"scratch.tmp", true},
+		{"vendor/github.com/dep/dep.go", true},
+		{".git/HEAD", true},
+		{"main.go", false},
+	}
+	for _, tc := range testCases {
+		if got := ignorer.MatchesPath(tc.path); got != tc.expected {
+			t.Errorf("MatchesPath(%q) = %v, want %v", tc.path, got, tc.expected)
+		}
+	}
+}
@@ -0,0 +1,125 @@
+package inputfiles
+
+import (
+	"fmt"
+	"io/fs"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/gabriel-vasile/mimetype"
+	gitignore "github.com/sabhiram/go-gitignore"
+)
+
+// DefaultMaxSize is the default limit, in bytes, on the combined size of the files attached to a prompt
+const DefaultMaxSize = 1 << 20
+
+// Collect resolves the glob patterns against fsys and returns the matching text files in sorted order.
+// Matched directories are walked recursively. Paths matched by the ignorer are excluded, as are
+// non-text files found while walking a directory. A pattern that matches nothing, or that names a
+// non-text file directly, is an error.
+func Collect(fsys fs.FS, patterns []string, ignorer *gitignore.GitIgnore) ([]string, error) {
+	seen := make(map[string]bool)
+	var files []string
+	add := func(p string) {
+		if !seen[p] {
+			seen[p] = true
+			files = append(files, p)
+		}
+	}
+
+	for _, pattern := range patterns {
+		pattern = path.Clean(strings.TrimPrefix(pattern, "./"))
+		matches, err := fs.Glob(fsys, pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid file pattern %q: %w", pattern, err)
+		}
+		if len(matches) == 0 {
+			return nil, fmt.Errorf("file pattern %q did not match any files", pattern)
+		}
+
+		for _, match := range matches {
+			if ignorer.MatchesPath(match) {
+				continue
+			}
+			info, err := fs.Stat(fsys, match)
+			if err != nil {
+				return nil, fmt.Errorf("error reading %s: %w", match, err)
+			}
+			if !info.IsDir() {
+				isText, err := isTextFile(fsys, match)
+				if err != nil {
+					return nil, err
+				}
+				if !isText {
+					return nil, fmt.Errorf("file %s is not a text file", match)
+				}
+				add(match)
+				continue
+			}
+
+			err = fs.WalkDir(fsys, match, func(p string, d fs.DirEntry, err error) error {
+				if err != nil {
+					return err
+				}
+				if ignorer.MatchesPath(p) {
+					if d.IsDir() {
+						return fs.SkipDir
+					}
+					return nil
+				}
+				if d.IsDir() {
+					return nil
+				}
+				isText, err := isTextFile(fsys, p)
+				if err != nil {
+					return err
+				}
+				if isText {
+					add(p)
+				}
+				return nil
+			})
+			if err != nil {
+				return nil, fmt.Errorf("error walking directory %s: %w", match, err)
+			}
+		}
+	}
+
+	sort.Strings(files)
+	return files, nil
+}
+
+// Render reads the files and formats each one with a path header, in the same shape the agent's
+// file tools use. It fails before reading further once the combined size exceeds maxSize.
+func Render(fsys fs.FS, files []string, maxSize int) (string, error) {
+	var sb strings.Builder
+	total := 0
+	for _, file := range files {
+		content, err := fs.ReadFile(fsys, file)
+		if err != nil {
+			return "", fmt.Errorf("error reading file %s: %w", file, err)
+		}
+		total += len(content)
+		if total > maxSize {
+			return "", fmt.Errorf("attached files exceed the size limit of %d bytes (reached while reading %s); narrow the -files patterns", maxSize, file)
+		}
+		sb.WriteString(fmt.Sprintf("File: %s\nContent:\n```%s```\n\n", file, string(content)))
+	}
+	return sb.String(), nil
+}
+
+// isTextFile reports whether the file is text. Besides text/* types, this includes formats detected as
+// subtypes of text, such as JSON, XML and SVG
+func isTextFile(fsys fs.FS, p string) (bool, error) {
+	content, err := fs.ReadFile(fsys, p)
+	if err != nil {
+		return false, fmt.Errorf("error reading file %s: %w", p, err)
+	}
+	for mime := mimetype.Detect(content); mime != nil; mime = mime.Parent() {
+		if mime.Is("text/plain") {
+			return true, nil
+		}
+	}
+	return false, nil
+}
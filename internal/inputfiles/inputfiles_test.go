@@ -0,0 +1,108 @@
+package inputfiles
+
+import (
+	"testing"
+	"testing/fstest"
+
+	gitignore "github.com/sabhiram/go-gitignore"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testFS() fstest.MapFS {
+	return fstest.MapFS{
+		"main.go":               {Data: []byte("package main\n\nfunc main() {}\n")},
+		"README.md":             {Data: []byte("# Project\n")},
+		"pkg/util.go":           {Data: []byte("package pkg\n")},
+		"pkg/util_test.go":      {Data: []byte("package pkg\n")},
+		"pkg/logo.png":          {Data: []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR")},
+		"vendor/dep/dep.go":     {Data: []byte("package dep\n")},
+		"generated/big.gen.go":  {Data: []byte("package generated\n")},
+		"generated/small.go":    {Data: []byte("package generated\n")},
+		"docs/nested/design.md": {Data: []byte("design\n")},
+		"web/package.json":      {Data: []byte(`{"name": "web", "scripts": {"test": "jest"}}`)},
+		"web/icon.svg":          {Data: []byte(`<svg xmlns="http://www.w3.org/2000/svg" width="8" height="8"></svg>`)},
+		"web/font.woff2":        {Data: []byte("wOF2\x00\x01\x00\x00")},
+	}
+}
+
+func TestCollect(t *testing.T) {
+	ignorer := gitignore.CompileIgnoreLines("vendor/", "*.gen.go")
+
+	tests := []struct {
+		name     string
+		patterns []string
+		expected []string
+		wantErr  string
+	}{
+		{
+			name:     "single file",
+			patterns: []string{"main.go"},
+			expected: []string{"main.go"},
+		},
+		{
+			name:     "glob with leading dot slash",
+			patterns: []string{"./pkg/*.go"},
+			expected: []string{"pkg/util.go", "pkg/util_test.go"},
+		},
+		{
+			name:     "directory is walked recursively and skips binary files",
+			patterns: []string{"pkg", "docs"},
+			expected: []string{"docs/nested/design.md", "pkg/util.go", "pkg/util_test.go"},
+		},
+		{
+			name:     "json and svg files are text",
+			patterns: []string{"web"},
+			expected: []string{"web/icon.svg", "web/package.json"},
+		},
+		{
+			name:     "explicit json file",
+			patterns: []string{"web/package.json"},
+			expected: []string{"web/package.json"},
+		},
+		{
+			name:     "ignored files are excluded",
+			patterns: []string{"*/*.go", "vendor/dep/dep.go"},
+			expected: []string{"generated/small.go", "pkg/util.go", "pkg/util_test.go"},
+		},
+		{
+			name:     "duplicates across patterns are removed",
+			patterns: []string{"main.go", "*.go"},
+			expected: []string{"main.go"},
+		},
+		{
+			name:     "pattern without matches",
+			patterns: []string{"*.py"},
+			wantErr:  `file pattern "*.py" did not match any files`,
+		},
+		{
+			name:     "explicit binary file",
+			patterns: []string{"pkg/logo.png"},
+			wantErr:  "file pkg/logo.png is not a text file",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			files, err := Collect(testFS(), tt.patterns, ignorer)
+			if tt.wantErr != "" {
+				assert.EqualError(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, files)
+		})
+	}
+}
+
+func TestRender(t *testing.T) {
+	fsys := testFS()
+
+	out, err := Render(fsys, []string{"README.md", "main.go"}, DefaultMaxSize)
+	require.NoError(t, err)
+	assert.Equal(t, "File: README.md\nContent:\n```# Project\n```\n\n"+
+		"File: main.go\nContent:\n```package main\n\nfunc main() {}\n```\n\n", out)
+
+	_, err = Render(fsys, []string{"README.md", "main.go"}, 20)
+	assert.EqualError(t, err, "attached files exceed the size limit of 20 bytes (reached while reading main.go); narrow the -files patterns")
+}
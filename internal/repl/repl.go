@@ -0,0 +1,85 @@
+package repl
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/spachava753/cpe/internal/agent"
+)
+
+// NewExecutorFunc creates an executor, with an empty conversation, for the given model
+type NewExecutorFunc func(model string) (agent.Executor, error)
+
+const helpText = `Commands:
+  /new            start a new conversation with the current model
+  /model <name>   switch to another model, starting a new conversation
+  /help           show this help
+  /exit           end the session`
+
+// Run starts an interactive session that reads one user message per line from in and runs it as a turn of the
+// current conversation, so the model sees everything said so far. If firstInput is non-empty it is sent as the
+// first turn before any line is read. Errors from a turn are reported to out and the session continues.
+// The session ends on /exit or when in is exhausted.
+func Run(in io.Reader, out io.Writer, model string, firstInput string, newExecutor NewExecutorFunc) error {
+	var executor agent.Executor
+	runTurn := func(input string) error {
+		if executor == nil {
+			var err error
+			executor, err = newExecutor(model)
+			if err != nil {
+				return err
+			}
+		}
+		return executor.Execute(input)
+	}
+
+	if firstInput != "" {
+		if err := runTurn(firstInput); err != nil {
+			fmt.Fprintf(out, "error: %s\n", err)
+		}
+	}
+
+	scanner := bufio.NewScanner(in)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for {
+		fmt.Fprint(out, "> ")
+		if !scanner.Scan() {
+			fmt.Fprintln(out)
+			return scanner.Err()
+		}
+		line := strings.TrimSpace(scanner.Text())
+
+		switch {
+		case line == "":
+			continue
+		case line == "/exit" || line == "/quit":
+			return nil
+		case line == "/help":
+			fmt.Fprintln(out, helpText)
+		case line == "/new":
+			executor = nil
+			fmt.Fprintf(out, "started a new conversation with %s\n", model)
+		case strings.HasPrefix(line, "/model"):
+			name := strings.TrimSpace(strings.TrimPrefix(line, "/model"))
+			if name == "" {
+				fmt.Fprintf(out, "current model: %s\n", model)
+				continue
+			}
+			newExec, err := newExecutor(name)
+			if err != nil {
+				fmt.Fprintf(out, "error: %s\n", err)
+				continue
+			}
+			model, executor = name, newExec
+			fmt.Fprintf(out, "switched to %s, started a new conversation\n", model)
+		case strings.HasPrefix(line, "/"):
+			fmt.Fprintf(out, "unknown command %s\n%s\n", strings.Fields(line)[0], helpText)
+		default:
+			if err := runTurn(line); err != nil {
+				fmt.Fprintf(out, "error: %s\n", err)
+			}
+		}
+	}
+}
@@ -0,0 +1,82 @@
+package repl
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/spachava753/cpe/internal/agent"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// stubExecutor records the dialog it has been sent, like a real executor continuing its conversation
+type stubExecutor struct {
+	model  string
+	dialog []string
+}
+
+func (s *stubExecutor) Execute(input string) error {
+	if input == "fail" {
+		return errors.New("provider unavailable")
+	}
+	s.dialog = append(s.dialog, input)
+	return nil
+}
+
+func TestRun(t *testing.T) {
+	var executors []*stubExecutor
+	newExecutor := func(model string) (agent.Executor, error) {
+		if model == "unknown" {
+			return nil, errors.New("unknown model 'unknown'")
+		}
+		e := &stubExecutor{model: model}
+		executors = append(executors, e)
+		return e, nil
+	}
+
+	script := strings.Join([]string{
+		"what does main.go do?",
+		"",
+		"and the tests?",
+		"fail",
+		"/model unknown",
+		"/model gpt-4o",
+		"second model",
+		"/new",
+		"fresh start",
+		"/bogus",
+		"/exit",
+		"never sent",
+	}, "\n")
+
+	var out bytes.Buffer
+	err := Run(strings.NewReader(script), &out, "claude-3-5-sonnet", "initial prompt", newExecutor)
+	require.NoError(t, err)
+
+	require.Len(t, executors, 3)
+	assert.Equal(t, "claude-3-5-sonnet", executors[0].model)
+	assert.Equal(t, []string{"initial prompt", "what does main.go do?", "and the tests?"}, executors[0].dialog)
+	assert.Equal(t, "gpt-4o", executors[1].model)
+	assert.Equal(t, []string{"second model"}, executors[1].dialog)
+	assert.Equal(t, "gpt-4o", executors[2].model)
+	assert.Equal(t, []string{"fresh start"}, executors[2].dialog)
+
+	output := out.String()
+	assert.Contains(t, output, "error: provider unavailable")
+	assert.Contains(t, output, "error: unknown model 'unknown'")
+	assert.Contains(t, output, "switched to gpt-4o, started a new conversation")
+	assert.Contains(t, output, "started a new conversation with gpt-4o")
+	assert.Contains(t, output, "unknown command /bogus")
+}
+
+func TestRunEndsAtEOF(t *testing.T) {
+	var executor *stubExecutor
+	err := Run(strings.NewReader("hello\nworld"), &bytes.Buffer{}, "claude-3-5-sonnet", "", func(model string) (agent.Executor, error) {
+		executor = &stubExecutor{model: model}
+		return executor, nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"hello", "world"}, executor.dialog)
+}
@@ -2,29 +2,24 @@ package tokentree
 
 import (
 	"fmt"
-	"github.com/pkoukk/tiktoken-go"
 	gitignore "github.com/sabhiram/go-gitignore"
-	"github.com/spachava753/cpe/internal/tiktokenloader"
 	"io/fs"
 	"os"
 	"path/filepath"
 	"strings"
 )
 
-// buildTokenTree builds a tree of directories and files with their token counts
-func buildTokenTree(fsys fs.FS, ignorer *gitignore.GitIgnore) (map[string]int, error) {
-	// Initialize tiktoken
-	loader := tiktokenloader.NewOfflineLoader()
-	tiktoken.SetBpeLoader(loader)
-	encoding, err := tiktoken.GetEncoding("o200k_base")
-	if err != nil {
-		return nil, fmt.Errorf("error initializing tiktoken: %w", err)
-	}
+// Tokenizer counts the tokens in a text
+type Tokenizer interface {
+	CountTokens(text string) (int, error)
+}
 
+// buildTokenTree builds a tree of directories and files with their token counts
+func buildTokenTree(fsys fs.FS, ignorer *gitignore.GitIgnore, tokenizer Tokenizer) (map[string]int, error) {
 	tt := make(map[string]int)
 
 	// Walk the directory tree
-	err = fs.WalkDir(fsys, ".", func(currentPath string, d fs.DirEntry, err error) error {
+	err := fs.WalkDir(fsys, ".", func(currentPath string, d fs.DirEntry, err error) error {
 		if err != nil {
 			return err
 		}
@@ -47,8 +42,10 @@ func buildTokenTree(fsys fs.FS, ignorer *gitignore.GitIgnore) (map[string]int, e
 				return fmt.Errorf("error reading file %s: %w", currentPath, err)
 			}
 
-			tokens := encoding.Encode(string(content), nil, nil)
-			tokenCount := len(tokens)
+			tokenCount, err := tokenizer.CountTokens(string(content))
+			if err != nil {
+				return fmt.Errorf("error counting tokens of file %s: %w", currentPath, err)
+			}
 
 			// Store the file's token count
 			tt[currentPath] = tokenCount
@@ -73,9 +70,9 @@ func buildTokenTree(fsys fs.FS, ignorer *gitignore.GitIgnore) (map[string]int, e
 	return tt, nil
 }
 
-// PrintTokenTree prints a formatted representation of the token tree
-func PrintTokenTree(fsys fs.FS, ignorer *gitignore.GitIgnore) error {
-	tree, err := buildTokenTree(fsys, ignorer)
+// PrintTokenTree prints a formatted representation of the token tree, counted with tokenizer
+func PrintTokenTree(fsys fs.FS, ignorer *gitignore.GitIgnore, tokenizer Tokenizer) error {
+	tree, err := buildTokenTree(fsys, ignorer, tokenizer)
 	if err != nil {
 		return err
 	}
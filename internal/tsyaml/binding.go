@@ -0,0 +1,16 @@
+// Package tsyaml is the tree-sitter YAML grammar, generated from https://github.com/ikatyang/tree-sitter-yaml
+// v0.5.0 (see LICENSE). The grammar has no Go bindings for github.com/tree-sitter/go-tree-sitter, so its sources are
+// kept here and exposed the way the official grammar modules are
+package tsyaml
+
+// #cgo CFLAGS: -std=c11 -fPIC
+// #include "parser.h"
+// const TSLanguage *tree_sitter_yaml(void);
+import "C"
+
+import "unsafe"
+
+// Language returns the tree-sitter Language for YAML, to be wrapped with sitter.NewLanguage
+func Language() unsafe.Pointer {
+	return unsafe.Pointer(C.tree_sitter_yaml())
+}
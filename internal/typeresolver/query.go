@@ -0,0 +1,86 @@
+package typeresolver
+
+import (
+	"fmt"
+	"github.com/spachava753/cpe/internal/tsyaml"
+	sitter "github.com/tree-sitter/go-tree-sitter"
+	golang "github.com/tree-sitter/tree-sitter-go/bindings/go"
+	java "github.com/tree-sitter/tree-sitter-java/bindings/go"
+	json "github.com/tree-sitter/tree-sitter-json/bindings/go"
+	python "github.com/tree-sitter/tree-sitter-python/bindings/go"
+)
+
+// Capture is a node captured by a tree-sitter query
+type Capture struct {
+	// Name is the capture name used in the query, without the leading "@"
+	Name string
+	// Text is the source text of the captured node
+	Text      string
+	StartByte uint
+	EndByte   uint
+	// StartPoint and EndPoint are zero based row and column positions of the captured node
+	StartPoint sitter.Point
+	EndPoint   sitter.Point
+}
+
+// languageByName returns the tree-sitter language for a language name or file extension
+func languageByName(name string) (*sitter.Language, error) {
+	switch name {
+	case "go":
+		return sitter.NewLanguage(golang.Language()), nil
+	case "java":
+		return sitter.NewLanguage(java.Language()), nil
+	case "python", "py":
+		return sitter.NewLanguage(python.Language()), nil
+	case "json":
+		return sitter.NewLanguage(json.Language()), nil
+	case "yaml", "yml":
+		return sitter.NewLanguage(tsyaml.Language()), nil
+	default:
+		return nil, fmt.Errorf("unsupported language: %s", name)
+	}
+}
+
+// RunQuery parses source as the given language ("go", "java", "python", "json" or "yaml") and runs the tree-sitter query,
+// an S-expression pattern, against it. The captured nodes are returned in the order they appear in the source
+func RunQuery(lang string, source []byte, query string) ([]Capture, error) {
+	language, err := languageByName(lang)
+	if err != nil {
+		return nil, err
+	}
+
+	parser := sitter.NewParser()
+	defer parser.Close()
+	if err := parser.SetLanguage(language); err != nil {
+		return nil, fmt.Errorf("failed to set language for %s: %v", lang, err)
+	}
+
+	q, qErr := sitter.NewQuery(language, query)
+	if qErr != nil {
+		return nil, fmt.Errorf("invalid query: %s (row: %d, column: %d, offset: %d, kind: %v)",
+			qErr.Message, qErr.Row, qErr.Column, qErr.Offset, qErr.Kind)
+	}
+	defer q.Close()
+
+	tree := parser.Parse(source, nil)
+	defer tree.Close()
+
+	cursor := sitter.NewQueryCursor()
+	defer cursor.Close()
+
+	var captures []Capture
+	names := q.CaptureNames()
+	queryCaptures := cursor.Captures(q, tree.RootNode(), source)
+	for match, index := queryCaptures.Next(); match != nil; match, index = queryCaptures.Next() {
+		capture := match.Captures[index]
+		captures = append(captures, Capture{
+			Name:       names[capture.Index],
+			Text:       capture.Node.Utf8Text(source),
+			StartByte:  capture.Node.StartByte(),
+			EndByte:    capture.Node.EndByte(),
+			StartPoint: capture.Node.StartPosition(),
+			EndPoint:   capture.Node.EndPosition(),
+		})
+	}
+	return captures, nil
+}
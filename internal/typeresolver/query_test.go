@@ -0,0 +1,110 @@
+package typeresolver
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	sitter "github.com/tree-sitter/go-tree-sitter"
+)
+
+func TestRunQuery(t *testing.T) {
+	tests := []struct {
+		name   string
+		lang   string
+		source string
+		query  string
+		want   []Capture
+	}{
+		{
+			name: "go function names",
+			lang: "go",
+			source: `package main
+
+func hello() {}
+
+func world() {}
+`,
+			query: `(function_declaration name: (identifier) @func.name)`,
+			want: []Capture{
+				{Name: "func.name", Text: "hello", StartByte: 19, EndByte: 24, StartPoint: sitter.Point{Row: 2, Column: 5}, EndPoint: sitter.Point{Row: 2, Column: 10}},
+				{Name: "func.name", Text: "world", StartByte: 36, EndByte: 41, StartPoint: sitter.Point{Row: 4, Column: 5}, EndPoint: sitter.Point{Row: 4, Column: 10}},
+			},
+		},
+		{
+			name: "python function names",
+			lang: "python",
+			source: `def hello():
+    pass
+
+class Greeter:
+    def greet(self):
+        pass
+`,
+			query: `(function_definition name: (identifier) @func.name)`,
+			want: []Capture{
+				{Name: "func.name", Text: "hello", StartByte: 4, EndByte: 9, StartPoint: sitter.Point{Row: 0, Column: 4}, EndPoint: sitter.Point{Row: 0, Column: 9}},
+				{Name: "func.name", Text: "greet", StartByte: 46, EndByte: 51, StartPoint: sitter.Point{Row: 4, Column: 8}, EndPoint: sitter.Point{Row: 4, Column: 13}},
+			},
+		},
+		{
+			name:   "no matches",
+			lang:   "go",
+			source: "package main\n",
+			query:  `(function_declaration name: (identifier) @func.name)`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := RunQuery(tt.lang, []byte(tt.source), tt.query)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestRunQueryErrors(t *testing.T) {
+	_, err := RunQuery("go", []byte("package main\n"), `(function_declaration name: (identifier) @func.name`)
+	assert.ErrorContains(t, err, "invalid query")
+
+	_, err = RunQuery("go", []byte("package main\n"), `(no_such_node) @x`)
+	assert.ErrorContains(t, err, "invalid query")
+
+	_, err = RunQuery("cobol", []byte(""), `(identifier) @x`)
+	assert.ErrorContains(t, err, "unsupported language: cobol")
+}
+
+func TestRunQueryJSONTopLevelKeys(t *testing.T) {
+	source := `{
+  "name": "cpe",
+  "scripts": {"test": "go test ./..."},
+  "tags": ["cli"]
+}`
+	captures, err := RunQuery("json", []byte(source), `(document (object (pair key: (string) @key)))`)
+	require.NoError(t, err)
+
+	var keys []string
+	for _, capture := range captures {
+		keys = append(keys, capture.Text)
+	}
+	assert.Equal(t, []string{`"name"`, `"scripts"`, `"tags"`}, keys)
+	assert.Equal(t, sitter.Point{Row: 2, Column: 2}, captures[1].StartPoint)
+}
+
+func TestRunQueryYAMLTopLevelKeys(t *testing.T) {
+	source := `name: cpe
+scripts:
+  test: go test ./...
+tags: [cli]
+`
+	captures, err := RunQuery("yaml", []byte(source), `(document (block_node (block_mapping (block_mapping_pair key: (flow_node) @key))))`)
+	require.NoError(t, err)
+
+	var keys []string
+	for _, capture := range captures {
+		keys = append(keys, capture.Text)
+	}
+	assert.Equal(t, []string{"name", "scripts", "tags"}, keys)
+	assert.Equal(t, sitter.Point{Row: 1, Column: 0}, captures[1].StartPoint)
+}
@@ -0,0 +1,119 @@
+package typeresolver
+
+import (
+	"cmp"
+	"fmt"
+	sitter "github.com/tree-sitter/go-tree-sitter"
+	"slices"
+	"strings"
+)
+
+// Symbol is the declaration of a named symbol, e.g. a function or a type, in a source file
+type Symbol struct {
+	Name string
+	// Source is the source text of the whole declaration
+	Source string
+	// StartLine and EndLine are the one based lines the declaration spans
+	StartLine uint
+	EndLine   uint
+}
+
+// symbolQueries capture the declarations of each language as @declaration and their names as @name
+var symbolQueries = map[string]string{
+	"go": `
+(function_declaration name: (identifier) @name) @declaration
+(method_declaration name: (field_identifier) @name) @declaration
+(type_declaration (type_spec name: (type_identifier) @name)) @declaration
+(type_declaration (type_alias name: (type_identifier) @name)) @declaration
+(const_declaration (const_spec name: (identifier) @name)) @declaration
+(var_declaration (var_spec name: (identifier) @name)) @declaration
+`,
+	"java": `
+(class_declaration name: (identifier) @name) @declaration
+(interface_declaration name: (identifier) @name) @declaration
+(enum_declaration name: (identifier) @name) @declaration
+(method_declaration name: (identifier) @name) @declaration
+(constructor_declaration name: (identifier) @name) @declaration
+`,
+	"python": `
+(function_definition name: (identifier) @name) @declaration
+(class_definition name: (identifier) @name) @declaration
+`,
+	// the symbols of a JSON document are the keys of its objects, declared with their values
+	"json": `
+(pair key: (string (string_content) @name)) @declaration
+`,
+	// the symbols of a YAML document are the plain keys of its block and flow mappings, declared with their values
+	"yaml": `
+(block_mapping_pair key: (flow_node (plain_scalar) @name)) @declaration
+(flow_pair key: (flow_node (plain_scalar) @name)) @declaration
+`,
+}
+
+// FindSymbol returns every declaration of the symbol called name in source, in source order. Methods of
+// different types can share a name, so there may be several. lang is "go", "java", "python", "json" or "yaml"
+func FindSymbol(lang string, source []byte, name string) ([]Symbol, error) {
+	switch lang {
+	case "py":
+		lang = "python"
+	case "yml":
+		lang = "yaml"
+	}
+	queryStr, ok := symbolQueries[lang]
+	if !ok {
+		return nil, fmt.Errorf("unsupported language: %s", lang)
+	}
+	language, err := languageByName(lang)
+	if err != nil {
+		return nil, err
+	}
+
+	parser := sitter.NewParser()
+	defer parser.Close()
+	if err := parser.SetLanguage(language); err != nil {
+		return nil, fmt.Errorf("failed to set language for %s: %v", lang, err)
+	}
+	tree := parser.Parse(source, nil)
+	defer tree.Close()
+
+	query, qErr := sitter.NewQuery(language, queryStr)
+	if qErr != nil {
+		return nil, fmt.Errorf("failed to create symbol query: %s", qErr.Message)
+	}
+	defer query.Close()
+	nameIdx, _ := query.CaptureIndexForName("name")
+	declarationIdx, _ := query.CaptureIndexForName("declaration")
+
+	cursor := sitter.NewQueryCursor()
+	defer cursor.Close()
+
+	var symbols []Symbol
+	seen := make(map[uint]bool)
+	matches := cursor.Matches(query, tree.RootNode(), source)
+	for match := matches.Next(); match != nil; match = matches.Next() {
+		var nameNode, declarationNode *sitter.Node
+		for _, capture := range match.Captures {
+			switch uint(capture.Index) {
+			case nameIdx:
+				nameNode = &capture.Node
+			case declarationIdx:
+				declarationNode = &capture.Node
+			}
+		}
+		if nameNode == nil || declarationNode == nil || nameNode.Utf8Text(source) != name || seen[declarationNode.StartByte()] {
+			continue
+		}
+		seen[declarationNode.StartByte()] = true
+		// a YAML block mapping that ends the document also spans its trailing line breaks
+		text := declarationNode.Utf8Text(source)
+		trimmed := strings.TrimRight(text, "\r\n")
+		symbols = append(symbols, Symbol{
+			Name:      name,
+			Source:    trimmed,
+			StartLine: declarationNode.StartPosition().Row + 1,
+			EndLine:   declarationNode.EndPosition().Row + 1 - uint(strings.Count(text[len(trimmed):], "\n")),
+		})
+	}
+	slices.SortFunc(symbols, func(a, b Symbol) int { return cmp.Compare(a.StartLine, b.StartLine) })
+	return symbols, nil
+}
@@ -0,0 +1,164 @@
+package typeresolver
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const symbolGoSource = `package shapes
+
+import "math"
+
+// Circle is a round shape
+type Circle struct {
+	Radius float64
+}
+
+type Square struct {
+	Side float64
+}
+
+// Area returns the area of the circle
+func (c Circle) Area() float64 {
+	return math.Pi * c.Radius * c.Radius
+}
+
+func (s Square) Area() float64 {
+	return s.Side * s.Side
+}
+
+func NewCircle(radius float64) Circle {
+	return Circle{Radius: radius}
+}
+
+const Unit = 1
+`
+
+func TestFindSymbol(t *testing.T) {
+	tests := []struct {
+		name   string
+		lang   string
+		source string
+		symbol string
+		want   []Symbol
+	}{
+		{
+			name:   "go function",
+			lang:   "go",
+			source: symbolGoSource,
+			symbol: "NewCircle",
+			want: []Symbol{{
+				Name:      "NewCircle",
+				Source:    "func NewCircle(radius float64) Circle {\n\treturn Circle{Radius: radius}\n}",
+				StartLine: 23,
+				EndLine:   25,
+			}},
+		},
+		{
+			name:   "go type",
+			lang:   "go",
+			source: symbolGoSource,
+			symbol: "Square",
+			want: []Symbol{{
+				Name:      "Square",
+				Source:    "type Square struct {\n\tSide float64\n}",
+				StartLine: 10,
+				EndLine:   12,
+			}},
+		},
+		{
+			name:   "go methods sharing a name",
+			lang:   "go",
+			source: symbolGoSource,
+			symbol: "Area",
+			want: []Symbol{
+				{
+					Name:      "Area",
+					Source:    "func (c Circle) Area() float64 {\n\treturn math.Pi * c.Radius * c.Radius\n}",
+					StartLine: 15,
+					EndLine:   17,
+				},
+				{
+					Name:      "Area",
+					Source:    "func (s Square) Area() float64 {\n\treturn s.Side * s.Side\n}",
+					StartLine: 19,
+					EndLine:   21,
+				},
+			},
+		},
+		{
+			name:   "go constant",
+			lang:   "go",
+			source: symbolGoSource,
+			symbol: "Unit",
+			want:   []Symbol{{Name: "Unit", Source: "const Unit = 1", StartLine: 27, EndLine: 27}},
+		},
+		{
+			name:   "python method",
+			lang:   "py",
+			source: "class Greeter:\n    def greet(self):\n        return 'hi'\n\ndef main():\n    pass\n",
+			symbol: "greet",
+			want:   []Symbol{{Name: "greet", Source: "def greet(self):\n        return 'hi'", StartLine: 2, EndLine: 3}},
+		},
+		{
+			name:   "java method",
+			lang:   "java",
+			source: "class Greeter {\n    String greet() {\n        return \"hi\";\n    }\n}\n",
+			symbol: "greet",
+			want:   []Symbol{{Name: "greet", Source: "String greet() {\n        return \"hi\";\n    }", StartLine: 2, EndLine: 4}},
+		},
+		{
+			name:   "json keys at any depth",
+			lang:   "json",
+			source: "{\n  \"name\": \"cpe\",\n  \"scripts\": {\n    \"test\": \"go test ./...\"\n  },\n  \"test\": true\n}\n",
+			symbol: "test",
+			want: []Symbol{
+				{Name: "test", Source: "\"test\": \"go test ./...\"", StartLine: 4, EndLine: 4},
+				{Name: "test", Source: "\"test\": true", StartLine: 6, EndLine: 6},
+			},
+		},
+		{
+			name:   "json object value",
+			lang:   "json",
+			source: "{\n  \"scripts\": {\n    \"test\": \"go test ./...\"\n  }\n}\n",
+			symbol: "scripts",
+			want:   []Symbol{{Name: "scripts", Source: "\"scripts\": {\n    \"test\": \"go test ./...\"\n  }", StartLine: 2, EndLine: 4}},
+		},
+		{
+			name:   "yaml keys at any depth",
+			lang:   "yaml",
+			source: "name: cpe\nscripts:\n  test: go test ./...\nmatrix: {test: true}\n",
+			symbol: "test",
+			want: []Symbol{
+				{Name: "test", Source: "test: go test ./...", StartLine: 3, EndLine: 3},
+				{Name: "test", Source: "test: true", StartLine: 4, EndLine: 4},
+			},
+		},
+		{
+			name:   "yaml mapping value",
+			lang:   "yml",
+			source: "name: cpe\nscripts:\n  test: go test ./...\n  lint: go vet ./...\n",
+			symbol: "scripts",
+			want:   []Symbol{{Name: "scripts", Source: "scripts:\n  test: go test ./...\n  lint: go vet ./...", StartLine: 2, EndLine: 4}},
+		},
+		{
+			name:   "missing symbol",
+			lang:   "go",
+			source: symbolGoSource,
+			symbol: "Triangle",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := FindSymbol(tt.lang, []byte(tt.source), tt.symbol)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+
+	_, err := FindSymbol("rust", []byte("fn main() {}"), "main")
+	assert.EqualError(t, err, "unsupported language: rust")
+}
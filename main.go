@@ -1,16 +1,22 @@
 package main
 
 import (
+	"bufio"
 	_ "embed"
+	"encoding/json"
 	"fmt"
 	"github.com/spachava753/cpe/internal/agent"
 	"github.com/spachava753/cpe/internal/cliopts"
 	"github.com/spachava753/cpe/internal/ignore"
+	"github.com/spachava753/cpe/internal/inputfiles"
+	"github.com/spachava753/cpe/internal/repl"
 	"github.com/spachava753/cpe/internal/tokentree"
 	"io"
 	"log/slog"
+	"math"
 	"os"
 	"runtime/debug"
+	"strings"
 	"time"
 )
 
@@ -23,7 +29,11 @@ func getVersion() string {
 }
 
 func main() {
-	logger := slog.Default()
+	level, err := parseLogLevel(os.Getenv("CPE_LOG_LEVEL"))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "warning: %s, using info\n", err)
+	}
+	logger := newLogger(os.Getenv("CPE_LOG_FILE"), level, os.Stderr)
 	startTime := time.Now()
 	defer func() {
 		elapsed := time.Since(startTime)
@@ -32,28 +42,34 @@ func main() {
 
 	config, err := parseConfig()
 	if err != nil {
-		logger.Error("fatal error", slog.Any("err", err))
+		slog.Error("fatal error", slog.Any("err", err))
 		os.Exit(1)
 	}
 
 	if config.TokenCountPath != "" {
 		ignorer, err := ignore.LoadIgnoreFiles(".")
 		if err != nil {
-			logger.Error("fatal error", slog.Any("err", err))
+			slog.Error("fatal error", slog.Any("err", err))
 			os.Exit(1)
 		}
 		if ignorer == nil {
-			logger.Error("git ignorer was nil")
+			slog.Error("git ignorer was nil")
 			os.Exit(1)
 		}
-		if err := tokentree.PrintTokenTree(os.DirFS("."), ignorer); err != nil {
+		if err := tokentree.PrintTokenTree(os.DirFS("."), ignorer, agent.NewTokenizer(config.Model)); err != nil {
 			slog.Error("fatal error", slog.Any("err", err))
 			os.Exit(1)
 		}
 		return
 	}
 
-	executor, err := agent.InitExecutor(logger, agent.ModelOptions{
+	responseSchema, err := readResponseSchema(config.ResponseSchema)
+	if err != nil {
+		slog.Error("fatal error", slog.Any("err", err))
+		os.Exit(1)
+	}
+
+	modelOptions := agent.ModelOptions{
 		Model:             config.Model,
 		CustomURL:         config.CustomURL,
 		MaxTokens:         config.MaxTokens,
@@ -63,19 +79,101 @@ func main() {
 		FrequencyPenalty:  config.FrequencyPenalty,
 		PresencePenalty:   config.PresencePenalty,
 		NumberOfResponses: config.NumberOfResponses,
+		MaxIterations:     config.MaxIterations,
+		MaxToolRepeats:    config.MaxToolRepeats,
+		MaxParallelTools:  config.MaxParallelTools,
+		FetchDomains:      config.FetchDomains,
+		ToolDescriptions:  config.ToolDescriptions,
+		TestCommand:       config.TestCommand,
+		ResponseFormat:    config.ResponseFormat,
+		ResponseSchema:    responseSchema,
+		MaxInputTokens:    config.MaxInputTokens,
+		GitContext:        config.GitContext,
+		ContextCommand:    config.ContextCommand,
+		SpillToolOutput:   config.SpillToolOutput,
+		Headers:           config.Headers,
+		WorkDir:           config.WorkDir,
 		Input:             config.Input,
 		Version:           config.Version,
-	})
+	}
+
+	var output *responseWriter
+	if !stdoutIsTerminal() {
+		output = &responseWriter{w: os.Stdout, sep: parseOutputSeparator(config.OutputSeparator)}
+	}
+
+	if config.Interactive {
+		firstInput, err := interactiveFirstInput(config.Input, config.Prompt)
+		if err != nil {
+			slog.Error("fatal error", slog.Any("err", err))
+			os.Exit(1)
+		}
+		if len(config.Files) > 0 {
+			attached, err := attachFiles(config.WorkDir, config.Files)
+			if err != nil {
+				slog.Error("fatal error", slog.Any("err", err))
+				os.Exit(1)
+			}
+			firstInput = attached + firstInput
+		}
+		err = repl.Run(os.Stdin, os.Stderr, config.Model, firstInput, func(model string) (agent.Executor, error) {
+			opts := modelOptions
+			opts.Model = model
+			executor, err := agent.InitExecutor(logger, opts)
+			if err != nil {
+				return nil, err
+			}
+			return printResponses(executor, output), nil
+		})
+		if err != nil {
+			slog.Error("fatal error", slog.Any("err", err))
+			os.Exit(1)
+		}
+		return
+	}
+
+	stdin := pipedStdin()
+	if config.Input == "-" {
+		stdin = os.Stdin
+	}
+	input, err := readInput(config.Input, config.Prompt, stdin)
 	if err != nil {
 		slog.Error("fatal error", slog.Any("err", err))
 		os.Exit(1)
 	}
 
-	input, err := readInput(config.Input)
+	if len(config.Files) > 0 {
+		attached, err := attachFiles(config.WorkDir, config.Files)
+		if err != nil {
+			slog.Error("fatal error", slog.Any("err", err))
+			os.Exit(1)
+		}
+		input = attached + input
+	}
+
+	if config.Plan {
+		newExecutor := func(readOnly bool) (agent.Executor, error) {
+			opts := modelOptions
+			opts.ReadOnly = readOnly
+			executor, err := agent.InitExecutor(logger, opts)
+			if err != nil || readOnly {
+				return executor, err
+			}
+			return printResponses(executor, output), nil
+		}
+		if err := agent.PlanThenExecute(newExecutor, input, confirmPlan); err != nil {
+			slog.Error("fatal error", slog.Any("err", err))
+			os.Exit(1)
+		}
+		return
+	}
+
+	executor, err := agent.InitExecutor(logger, modelOptions)
 	if err != nil {
 		slog.Error("fatal error", slog.Any("err", err))
 		os.Exit(1)
 	}
+	executor = printResponses(executor, output)
 
 	if err := executor.Execute(input); err != nil {
 		slog.Error("fatal error", slog.Any("err", err))
@@ -91,17 +189,91 @@ func parseConfig() (cliopts.Options, error) {
 		os.Exit(0)
 	}
 
-	if cliopts.Opts.Model != "" && cliopts.Opts.Model != agent.DefaultModel {
-		_, ok := agent.ModelConfigs[cliopts.Opts.Model]
-		if !ok && cliopts.Opts.CustomURL == "" {
-			return cliopts.Options{}, fmt.Errorf("unknown model '%s' requires -custom-url flag", cliopts.Opts.Model)
+	return cliopts.Opts, nil
+}
+
+// pipedStdin returns stdin if data is being piped or redirected from a file into the process, or nil otherwise.
+// Other kinds of stdin, such as a terminal or the socket or inherited descriptor given by cron, CI runners and IDEs,
+// may never reach EOF and are only read when requested with -input -
+func pipedStdin() io.Reader {
+	stat, err := os.Stdin.Stat()
+	if err != nil || !isPipedMode(stat.Mode()) {
+		return nil
+	}
+	return os.Stdin
+}
+
+// isPipedMode reports whether a file with the given mode is a pipe or a regular file, i.e. input that ends
+func isPipedMode(mode os.FileMode) bool {
+	return mode&os.ModeNamedPipe != 0 || mode.IsRegular()
+}
+
+// stdoutIsTerminal reports whether stdout is a terminal rather than a pipe or a file
+func stdoutIsTerminal() bool {
+	stat, err := os.Stdout.Stat()
+	return err == nil && stat.Mode()&os.ModeCharDevice != 0
+}
+
+// parseOutputSeparator returns the separator named by the -output-separator flag. Values other than
+// "newline" and "nul" are used literally
+func parseOutputSeparator(s string) string {
+	switch s {
+	case "", "newline":
+		return "\n"
+	case "nul":
+		return "\x00"
+	default:
+		return s
+	}
+}
+
+// responseWriter writes the model's final responses to w, with sep between consecutive responses but not
+// after the last one, so that the output can be split into records by a script reading it
+type responseWriter struct {
+	w       io.Writer
+	sep     string
+	written bool
+}
+
+func (r *responseWriter) write(response string) error {
+	if r.written {
+		if _, err := io.WriteString(r.w, r.sep); err != nil {
+			return err
 		}
 	}
+	r.written = true
+	_, err := io.WriteString(r.w, response)
+	return err
+}
 
-	return cliopts.Opts, nil
+// printingExecutor writes the final response to every input to out once the input is executed
+type printingExecutor struct {
+	agent.Executor
+	out *responseWriter
 }
 
-func readInput(inputPath string) (string, error) {
+func (p printingExecutor) Execute(input string) error {
+	if err := p.Executor.Execute(input); err != nil {
+		return err
+	}
+	if err := p.out.write(agent.FinalResponse(p.Executor)); err != nil {
+		return fmt.Errorf("error writing response: %w", err)
+	}
+	return nil
+}
+
+// printResponses wraps executor so that its final responses are written to out. If out is nil,
+// executor is returned unchanged
+func printResponses(executor agent.Executor, out *responseWriter) agent.Executor {
+	if out == nil {
+		return executor
+	}
+	return printingExecutor{Executor: executor, out: out}
+}
+
+// readInput assembles the input for the agent from the input file or stdin, followed by the command line prompt.
+// stdin is only read when no input file is given and it is non-nil.
+func readInput(inputPath string, prompt string, stdin io.Reader) (string, error) {
 	var input string
 
 	// Read from stdin or file if provided
@@ -112,9 +284,9 @@ func readInput(inputPath string) (string, error) {
 			return "", fmt.Errorf("error opening input file %s: %w", inputPath, err)
 		}
 		input = string(content)
-	} else if inputPath == "-" {
+	} else if stdin != nil {
 		// Read from stdin
-		content, err := io.ReadAll(os.Stdin)
+		content, err := io.ReadAll(stdin)
 		if err != nil {
 			return "", err
 		}
@@ -122,17 +294,141 @@ func readInput(inputPath string) (string, error) {
 	}
 
 	// If we have a prompt from command line arguments, append it to any existing input
-	if cliopts.Opts.Prompt != "" {
-		if input != "" {
-			input = input + "\n\n" + cliopts.Opts.Prompt
+	if prompt != "" {
+		if strings.TrimSpace(input) != "" {
+			input = input + "\n\n" + prompt
 		} else {
-			input = cliopts.Opts.Prompt
+			input = prompt
 		}
 	}
 
-	if input == "" {
+	if strings.TrimSpace(input) == "" {
 		return "", fmt.Errorf("no input provided. Please provide input via stdin, input file, or as a command line argument")
 	}
 
 	return input, nil
 }
+
+// interactiveFirstInput returns the first message of an interactive session: the input file followed by the command
+// line prompt, or just the prompt, which may be empty. Stdin cannot be the input file, since it carries the messages
+func interactiveFirstInput(inputPath string, prompt string) (string, error) {
+	if inputPath == "-" {
+		return "", fmt.Errorf("-input - cannot be used with -interactive, which reads its messages from stdin")
+	}
+	if inputPath == "" {
+		return prompt, nil
+	}
+	return readInput(inputPath, prompt, nil)
+}
+
+// attachFiles renders the contents of the files matched by the patterns so they can be prepended to the prompt.
+// Patterns are relative to dir, the working directory set with -workdir, or the current directory when empty.
+// Files ignored by .cpeignore or .gitignore are skipped.
+func attachFiles(dir string, patterns []string) (string, error) {
+	if dir == "" {
+		dir = "."
+	}
+	ignorer, err := ignore.LoadIgnoreFilesWithGitignore(dir)
+	if err != nil {
+		return "", fmt.Errorf("failed to load ignore files: %w", err)
+	}
+	if ignorer == nil {
+		return "", fmt.Errorf("git ignorer was nil")
+	}
+
+	fsys := os.DirFS(dir)
+	files, err := inputfiles.Collect(fsys, patterns, ignorer)
+	if err != nil {
+		return "", err
+	}
+	return inputfiles.Render(fsys, files, inputfiles.DefaultMaxSize)
+}
+
+// readResponseSchema reads the JSON schema file the final response must follow. An empty path means no schema
+func readResponseSchema(path string) (map[string]any, error) {
+	if path == "" {
+		return nil, nil
+	}
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading response schema %s: %w", path, err)
+	}
+	var schema map[string]any
+	if err := json.Unmarshal(content, &schema); err != nil {
+		return nil, fmt.Errorf("error parsing response schema %s: %w", path, err)
+	}
+	return schema, nil
+}
+
+// confirmPlan shows the plan and asks the user whether it should be executed. The plan is written to stderr, since
+// the logs the model's response was written to may be redirected or filtered. The answer is read from the terminal,
+// since stdin may already have been consumed as input
+func confirmPlan(plan string) (bool, error) {
+	in := io.Reader(os.Stdin)
+	if pipedStdin() != nil {
+		tty, err := os.Open("/dev/tty")
+		if err != nil {
+			return false, fmt.Errorf("cannot ask for plan approval without a terminal: %w", err)
+		}
+		defer tty.Close()
+		in = tty
+	}
+	return askApproval(plan, in, os.Stderr)
+}
+
+// askApproval shows the plan on out, prompts for approval and reports whether the answer read from in is yes
+func askApproval(plan string, in io.Reader, out io.Writer) (bool, error) {
+	fmt.Fprintf(out, "Plan:\n%s\n\nExecute this plan? [y/N] ", strings.TrimSpace(plan))
+	answer, err := bufio.NewReader(in).ReadString('\n')
+	if err != nil && err != io.EOF {
+		return false, fmt.Errorf("failed to read plan approval: %w", err)
+	}
+	switch strings.ToLower(strings.TrimSpace(answer)) {
+	case "y", "yes":
+		return true, nil
+	default:
+		return false, nil
+	}
+}
+
+// newLogger returns the logger for the agent's progress, writing records at or above level to the destination set
+// by CPE_LOG_FILE: "stderr" or empty for the default logger, "none" to disable logging, or a file path that logs are
+// appended to. If the log file cannot be opened, a single warning is written to stderr and logging is disabled.
+func newLogger(dest string, level slog.Level, stderr io.Writer) *slog.Logger {
+	switch dest {
+	case "", "stderr":
+		slog.SetLogLoggerLevel(level)
+		return slog.Default()
+	case "none":
+		return discardLogger()
+	}
+
+	f, err := os.OpenFile(dest, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		fmt.Fprintf(stderr, "warning: could not open log file, logging is disabled: %s\n", err)
+		return discardLogger()
+	}
+	return slog.New(slog.NewTextHandler(f, &slog.HandlerOptions{Level: level}))
+}
+
+// parseLogLevel parses the CPE_LOG_LEVEL value: debug, info, warn or error, case insensitively.
+// An empty value is info. An invalid value returns an error along with the info level.
+func parseLogLevel(s string) (slog.Level, error) {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "debug":
+		return slog.LevelDebug, nil
+	case "", "info":
+		return slog.LevelInfo, nil
+	case "warn", "warning":
+		return slog.LevelWarn, nil
+	case "error":
+		return slog.LevelError, nil
+	default:
+		return slog.LevelInfo, fmt.Errorf("invalid CPE_LOG_LEVEL %q, expected one of debug, info, warn or error", s)
+	}
+}
+
+// discardLogger returns a logger that drops every record without formatting it
+func discardLogger() *slog.Logger {
+	return slog.New(slog.NewTextHandler(io.Discard, &slog.HandlerOptions{Level: slog.Level(math.MaxInt)}))
+}
@@ -0,0 +1,264 @@
+package main
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReadInput(t *testing.T) {
+	inputFile := filepath.Join(t.TempDir(), "input.txt")
+	require.NoError(t, os.WriteFile(inputFile, []byte("from file"), 0644))
+
+	tests := []struct {
+		name      string
+		inputPath string
+		prompt    string
+		stdin     string
+		piped     bool
+		expected  string
+		wantErr   bool
+	}{
+		{
+			name:     "prompt only",
+			prompt:   "fix this",
+			expected: "fix this",
+		},
+		{
+			name:     "piped stdin becomes the prompt",
+			stdin:    "fix this\n",
+			piped:    true,
+			expected: "fix this\n",
+		},
+		{
+			name:     "piped stdin is combined with the prompt",
+			prompt:   "review",
+			stdin:    "package main",
+			piped:    true,
+			expected: "package main\n\nreview",
+		},
+		{
+			name:      "input file takes precedence over piped stdin",
+			inputPath: inputFile,
+			prompt:    "review",
+			stdin:     "ignored",
+			piped:     true,
+			expected:  "from file\n\nreview",
+		},
+		{
+			name:      "explicit stdin input",
+			inputPath: "-",
+			stdin:     "from stdin",
+			piped:     true,
+			expected:  "from stdin",
+		},
+		{
+			name:     "empty piped stdin falls back to the prompt",
+			prompt:   "hi",
+			stdin:    "  \n",
+			piped:    true,
+			expected: "hi",
+		},
+		{
+			name:    "no input",
+			stdin:   "\n",
+			piped:   true,
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var stdin io.Reader
+			if tt.piped {
+				stdin = strings.NewReader(tt.stdin)
+			}
+
+			input, err := readInput(tt.inputPath, tt.prompt, stdin)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, input)
+		})
+	}
+}
+
+func TestInteractiveFirstInput(t *testing.T) {
+	inputFile := filepath.Join(t.TempDir(), "input.txt")
+	require.NoError(t, os.WriteFile(inputFile, []byte("from file"), 0644))
+
+	input, err := interactiveFirstInput(inputFile, "review")
+	require.NoError(t, err)
+	assert.Equal(t, "from file\n\nreview", input)
+
+	input, err = interactiveFirstInput("", "review")
+	require.NoError(t, err)
+	assert.Equal(t, "review", input)
+
+	input, err = interactiveFirstInput("", "")
+	require.NoError(t, err)
+	assert.Empty(t, input)
+
+	_, err = interactiveFirstInput("-", "")
+	assert.EqualError(t, err, "-input - cannot be used with -interactive, which reads its messages from stdin")
+}
+
+func TestAskApproval(t *testing.T) {
+	tests := []struct {
+		answer   string
+		approved bool
+	}{
+		{answer: "y\n", approved: true},
+		{answer: " YES \n", approved: true},
+		{answer: "n\n", approved: false},
+		{answer: "\n", approved: false},
+		{answer: "", approved: false},
+	}
+
+	for _, tt := range tests {
+		var out strings.Builder
+		approved, err := askApproval("1. Move the tools\n2. Update the imports\n", strings.NewReader(tt.answer), &out)
+		require.NoError(t, err)
+		assert.Equal(t, tt.approved, approved, "answer %q", tt.answer)
+		assert.Equal(t, "Plan:\n1. Move the tools\n2. Update the imports\n\nExecute this plan? [y/N] ", out.String())
+	}
+}
+
+func TestNewLogger(t *testing.T) {
+	dir := t.TempDir()
+
+	t.Run("stderr", func(t *testing.T) {
+		var stderr strings.Builder
+		assert.Same(t, slog.Default(), newLogger("", slog.LevelInfo, &stderr))
+		assert.Same(t, slog.Default(), newLogger("stderr", slog.LevelInfo, &stderr))
+		assert.Empty(t, stderr.String())
+	})
+
+	t.Run("file", func(t *testing.T) {
+		path := filepath.Join(dir, "cpe.log")
+		var stderr strings.Builder
+		newLogger(path, slog.LevelInfo, &stderr).Info("first")
+		newLogger(path, slog.LevelInfo, &stderr).Info("second")
+		assert.Empty(t, stderr.String())
+
+		content, err := os.ReadFile(path)
+		require.NoError(t, err)
+		lines := strings.Split(strings.TrimSpace(string(content)), "\n")
+		require.Len(t, lines, 2)
+		assert.Contains(t, lines[0], "msg=first")
+		assert.Contains(t, lines[1], "msg=second")
+	})
+
+	t.Run("file at debug level", func(t *testing.T) {
+		path := filepath.Join(dir, "debug.log")
+		var stderr strings.Builder
+		logger := newLogger(path, slog.LevelDebug, &stderr)
+		logger.Debug("details")
+
+		content, err := os.ReadFile(path)
+		require.NoError(t, err)
+		assert.Contains(t, string(content), "level=DEBUG msg=details")
+	})
+
+	t.Run("disabled", func(t *testing.T) {
+		var stderr strings.Builder
+		logger := newLogger("none", slog.LevelInfo, &stderr)
+		assert.False(t, logger.Enabled(context.Background(), slog.LevelError))
+		assert.Empty(t, stderr.String())
+	})
+
+	t.Run("unwritable location", func(t *testing.T) {
+		var stderr strings.Builder
+		logger := newLogger(filepath.Join(dir, "missing", "cpe.log"), slog.LevelInfo, &stderr)
+		assert.False(t, logger.Enabled(context.Background(), slog.LevelError))
+		assert.Equal(t, 1, strings.Count(stderr.String(), "warning: could not open log file, logging is disabled"))
+	})
+}
+
+func TestParseLogLevel(t *testing.T) {
+	tests := []struct {
+		value    string
+		expected slog.Level
+		wantErr  string
+	}{
+		{value: "debug", expected: slog.LevelDebug},
+		{value: "", expected: slog.LevelInfo},
+		{value: "info", expected: slog.LevelInfo},
+		{value: "WARN", expected: slog.LevelWarn},
+		{value: " error ", expected: slog.LevelError},
+		{value: "verbose", expected: slog.LevelInfo, wantErr: `invalid CPE_LOG_LEVEL "verbose", expected one of debug, info, warn or error`},
+	}
+
+	for _, tt := range tests {
+		level, err := parseLogLevel(tt.value)
+		assert.Equal(t, tt.expected, level, "value %q", tt.value)
+		if tt.wantErr != "" {
+			assert.EqualError(t, err, tt.wantErr)
+		} else {
+			assert.NoError(t, err)
+		}
+	}
+}
+
+func TestParseOutputSeparator(t *testing.T) {
+	assert.Equal(t, "\n", parseOutputSeparator("newline"))
+	assert.Equal(t, "\n", parseOutputSeparator(""))
+	assert.Equal(t, "\x00", parseOutputSeparator("nul"))
+	assert.Equal(t, "---", parseOutputSeparator("---"))
+}
+
+func TestResponseWriter(t *testing.T) {
+	tests := []struct {
+		name      string
+		sep       string
+		responses []string
+		expected  string
+	}{
+		{name: "single response", sep: "\n", responses: []string{"done"}, expected: "done"},
+		{name: "newline separated", sep: "\n", responses: []string{"first", "second", "third"}, expected: "first\nsecond\nthird"},
+		{name: "nul separated", sep: "\x00", responses: []string{"line one\nline two", "next"}, expected: "line one\nline two\x00next"},
+		{name: "empty responses keep their place", sep: "\x00", responses: []string{"", "second"}, expected: "\x00second"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out strings.Builder
+			w := &responseWriter{w: &out, sep: tt.sep}
+			for _, response := range tt.responses {
+				require.NoError(t, w.write(response))
+			}
+			assert.Equal(t, tt.expected, out.String())
+			assert.False(t, strings.HasSuffix(out.String(), tt.sep))
+		})
+	}
+}
+
+func TestAttachFilesWorkDir(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "other.go"), []byte("package other\n"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "generated.go"), []byte("package generated\n"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, ".gitignore"), []byte("generated.go\n"), 0644))
+
+	attached, err := attachFiles(dir, []string{"*.go"})
+	require.NoError(t, err)
+	assert.Contains(t, attached, "package other")
+	assert.NotContains(t, attached, "package generated")
+	assert.NotContains(t, attached, "package main")
+}
+
+func TestIsPipedMode(t *testing.T) {
+	assert.True(t, isPipedMode(os.ModeNamedPipe|0600))
+	assert.True(t, isPipedMode(0644))
+	assert.False(t, isPipedMode(os.ModeDevice|os.ModeCharDevice|0620))
+	assert.False(t, isPipedMode(os.ModeSocket|0777))
+	assert.False(t, isPipedMode(os.ModeDir|0755))
+}